@@ -0,0 +1,128 @@
+package gitdb
+
+//Count returns the number of records in dataset that satisfy q.
+//A nil q counts every record in dataset
+func (g *gitdb) Count(dataset string, q *Query) (int, error) {
+	if !g.isRegistered(dataset) {
+		return 0, ErrInvalidDataset
+	}
+
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(recordIDs), nil
+}
+
+//GroupBy returns the number of records in dataset that satisfy q for each value of index
+func (g *gitdb) GroupBy(dataset string, index string, q *Query) (map[string]int, error) {
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupIndex(recordIDs, g.index(dataset, index)), nil
+}
+
+//Sum returns the total of the numeric values of index for records in dataset that satisfy q
+func (g *gitdb) Sum(dataset string, index string, q *Query) (float64, error) {
+	if !g.isRegistered(dataset) {
+		return 0, ErrInvalidDataset
+	}
+
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return sumIndex(recordIDs, g.index(dataset, index)), nil
+}
+
+//Min returns the smallest numeric value of index for records in dataset that satisfy q
+func (g *gitdb) Min(dataset string, index string, q *Query) (float64, error) {
+	if !g.isRegistered(dataset) {
+		return 0, ErrInvalidDataset
+	}
+
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return minIndex(recordIDs, g.index(dataset, index))
+}
+
+//Max returns the largest numeric value of index for records in dataset that satisfy q
+func (g *gitdb) Max(dataset string, index string, q *Query) (float64, error) {
+	if !g.isRegistered(dataset) {
+		return 0, ErrInvalidDataset
+	}
+
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return maxIndex(recordIDs, g.index(dataset, index))
+}
+
+//groupIndex counts recordIDs by their value in index
+func groupIndex(recordIDs []string, index gdbSimpleIndex) map[string]int {
+	groups := map[string]int{}
+	for _, recordID := range recordIDs {
+		groups[indexString(index[recordID])]++
+	}
+
+	return groups
+}
+
+//sumIndex adds up the numeric values of recordIDs in index
+func sumIndex(recordIDs []string, index gdbSimpleIndex) float64 {
+	var sum float64
+	for _, recordID := range recordIDs {
+		if n, ok := indexNumber(index[recordID]); ok {
+			sum += n
+		}
+	}
+
+	return sum
+}
+
+//minIndex returns the smallest numeric value of recordIDs in index
+func minIndex(recordIDs []string, index gdbSimpleIndex) (float64, error) {
+	return reduceIndex(recordIDs, index, func(a, b float64) bool { return b < a })
+}
+
+//maxIndex returns the largest numeric value of recordIDs in index
+func maxIndex(recordIDs []string, index gdbSimpleIndex) (float64, error) {
+	return reduceIndex(recordIDs, index, func(a, b float64) bool { return b > a })
+}
+
+//reduceIndex returns the numeric value of recordIDs in index that
+//replaces all others according to replace
+func reduceIndex(recordIDs []string, index gdbSimpleIndex, replace func(current, next float64) bool) (float64, error) {
+	var result float64
+	found := false
+	for _, recordID := range recordIDs {
+		n, ok := indexNumber(index[recordID])
+		if !ok {
+			continue
+		}
+
+		if !found || replace(result, n) {
+			result = n
+			found = true
+		}
+	}
+
+	if !found {
+		return 0, ErrNoRecords
+	}
+
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package gitdb_test
+
+import (
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+func TestAggregate(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	count, err := testDb.Count("Message", nil)
+	if err != nil || count != 10 {
+		t.Errorf("testDb.Count want: 10, got: %d (%v)", count, err)
+	}
+
+	count, err = testDb.Count("Message", gitdb.Where("MessageId", gitdb.SearchGreaterThan, 5))
+	if err != nil || count != 4 {
+		t.Errorf("testDb.Count want: 4, got: %d (%v)", count, err)
+	}
+
+	groups, err := testDb.GroupBy("Message", "From", nil)
+	if err != nil || len(groups) != 1 || groups["alice@example.com"] != 10 {
+		t.Errorf("testDb.GroupBy want: map[alice@example.com:10], got: %v (%v)", groups, err)
+	}
+
+	cases := []struct {
+		name string
+		fn   func(dataset string, index string, q *gitdb.Query) (float64, error)
+		want float64
+	}{
+		{"sum", testDb.Sum, 45},
+		{"min", testDb.Min, 0},
+		{"max", testDb.Max, 9},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.fn("Message", "MessageId", nil)
+			if err != nil {
+				t.Errorf("%s failed: %s", tc.name, err)
+			}
+			if got != tc.want {
+				t.Errorf("want: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := testDb.Max("Message", "From", nil); err == nil {
+		t.Errorf("testDb.Max should fail on non numeric index")
+	}
+}
@@ -173,8 +173,9 @@ func (m *Message) GetSchema() *gitdb.Schema {
 	//Indexes speed up searching
 	indexes := make(map[string]interface{})
 	indexes["From"] = m.From
+	indexes["MessageId"] = m.MessageId
 
-	return gitdb.NewSchema(name, block, record, indexes)
+	return gitdb.NewSchema(name, block, record, indexes).FullText("Body", m.Body)
 }
 
 func (m *Message) Validate() error     { return nil }
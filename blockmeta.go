@@ -0,0 +1,81 @@
+package gitdb
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//blockMeta holds what AutoBlock needs to know about a block file
+type blockMeta struct {
+	modTime  time.Time
+	fileSize int64
+	size     int64 //uncompressed
+	count    int
+	ids      map[string]bool
+}
+
+//blockMetaCache caches blockMeta by block file. An entry is reused for as long
+//as the modification time and size of its block file are unchanged
+type blockMetaCache struct {
+	mu    sync.Mutex
+	metas map[string]*blockMeta
+}
+
+func newBlockMetaCache() *blockMetaCache {
+	return &blockMetaCache{metas: map[string]*blockMeta{}}
+}
+
+//blockMetaCacheFor returns the blockMeta cache of the open connection to the gitdb at dbPath.
+//Without one a new cache is returned so that nothing is kept after the call
+func blockMetaCacheFor(dbPath string) *blockMetaCache {
+	absDbPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return newBlockMetaCache()
+	}
+
+	connsMu.Lock()
+	defer connsMu.Unlock()
+	for _, conn := range conns {
+		if g, ok := conn.(*gitdb); ok && g.blockMetas != nil && g.absDbPath() == absDbPath {
+			return g.blockMetas
+		}
+	}
+
+	return newBlockMetaCache()
+}
+
+//get returns the metadata of blockFile reading the block only if it changed since it was cached
+func (c *blockMetaCache) get(blockFile string, info os.FileInfo) (*blockMeta, error) {
+	c.mu.Lock()
+	meta, ok := c.metas[blockFile]
+	c.mu.Unlock()
+	if ok && meta.fileSize == info.Size() && meta.modTime.Equal(info.ModTime()) {
+		return meta, nil
+	}
+
+	block, err := db.OpenBlock(blockFile, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	meta = &blockMeta{
+		modTime:  info.ModTime(),
+		fileSize: info.Size(),
+		size:     block.Size(),
+		count:    block.RecordCount(),
+		ids:      map[string]bool{},
+	}
+	for _, id := range block.RecordIDs() {
+		meta.ids[id] = true
+	}
+
+	c.mu.Lock()
+	c.metas[blockFile] = meta
+	c.mu.Unlock()
+
+	return meta, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//compactDataset rewrites the blocks of dataset in the gitdb at dbPath into blocks
+//of at most limit records (method count) or bytes (method size) and commits the result.
+//The dataset index is removed so that gitdb rebuilds it with the new record ids
+func compactDataset(dbPath, dataset, method string, limit int64) error {
+	if len(dbPath) == 0 {
+		return errors.New("path to gitdb must be set with -p")
+	}
+
+	if len(dataset) == 0 {
+		return errors.New("dataset must be set with -d")
+	}
+
+	if limit <= 0 {
+		return errors.New("limit must be greater than 0")
+	}
+
+	var policy db.CompactPolicy
+	switch method {
+	case "count":
+		policy.MaxRecords = int(limit)
+	case "size":
+		policy.MaxBytes = limit
+	default:
+		return fmt.Errorf("invalid compact method: %s; use count or size", method)
+	}
+
+	dataDir := filepath.Join(dbPath, "data")
+	if err := checkRepo(dataDir); err != nil {
+		return err
+	}
+
+	moved, err := db.Compact(filepath.Join(dataDir, dataset), policy, "", nil)
+	if err != nil {
+		restore(dataDir, dataset)
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(dbPath, ".gitdb", "index", dataset)); err != nil {
+		return err
+	}
+
+	committed, err := commit(dataDir, dataset, "Compacting "+dataset)
+	if err != nil {
+		return err
+	}
+
+	if !committed {
+		fmt.Println(dataset + " is already compact")
+		return nil
+	}
+
+	fmt.Printf("%s compacted; %d records moved\n", dataset, len(moved))
+	return nil
+}
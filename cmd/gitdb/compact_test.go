@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+func Test_compactDataset(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "gitdb-compact")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dbPath)
+
+	dataDir := filepath.Join(dbPath, "data")
+	git := func(args ...string) {
+		args = append([]string{"-C", dataDir}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s", args, out)
+		}
+	}
+
+	//3 blocks of 2 records each
+	for i := 0; i < 3; i++ {
+		block := db.NewBlock(filepath.Join(dataDir, "Message", fmt.Sprintf("b%d.json", i)), nil)
+		for j := 0; j < 2; j++ {
+			block.Add(fmt.Sprintf("Message/b%d/%d", i, i*2+j), fmt.Sprintf(`{"MessageId":%d}`, i*2+j))
+		}
+		data, err := block.Encode()
+		if err != nil {
+			t.Fatalf("block.Encode failed: %s", err)
+		}
+		os.MkdirAll(filepath.Join(dataDir, "Message"), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dataDir, "Message", fmt.Sprintf("b%d.json", i)), data, 0744); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %s", err)
+		}
+	}
+
+	if err := compactDataset(dbPath, "Message", "count", 4); err == nil {
+		t.Errorf("compactDataset should fail outside a git repository")
+	}
+
+	git("init", "-q")
+	git("config", "user.name", "Tester")
+	git("config", "user.email", "tester@io")
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+
+	if err := compactDataset(dbPath, "Message", "count", 4); err != nil {
+		t.Fatalf("compactDataset failed: %s", err)
+	}
+
+	b0, err := db.OpenBlock(filepath.Join(dataDir, "Message", "b0.json"), nil)
+	if err != nil || b0.RecordCount() != 4 {
+		t.Errorf("want 4 records in b0, got: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, "Message", "b2.json")); !os.IsNotExist(err) {
+		t.Errorf("b2.json should be removed")
+	}
+
+	out, err := exec.Command("git", "-C", dataDir, "status", "--porcelain").CombinedOutput()
+	if err != nil || len(out) > 0 {
+		t.Errorf("compaction should be committed, got: %s", out)
+	}
+
+	if err := compactDataset(dbPath, "Message", "daily", 4); err == nil {
+		t.Errorf("compactDataset should fail with an invalid method")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//convertBlocks rewrites all block files of the gitdb at dbPath in format.
+//The index is removed so that gitdb rebuilds it with the new record positions
+func convertBlocks(dbPath string, format string) error {
+	if len(dbPath) == 0 {
+		return errors.New("path to gitdb must be set with -p")
+	}
+
+	converted, err := db.ConvertBlocks(filepath.Join(dbPath, "data"), db.Format(format))
+	for _, blockFile := range converted {
+		fmt.Println("converted " + blockFile)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(converted) > 0 {
+		if err := os.RemoveAll(filepath.Join(dbPath, ".gitdb", "index")); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%d blocks converted to %s; set Config.BlockFormat to %q and commit the changes\n", len(converted), format, format)
+	return nil
+}
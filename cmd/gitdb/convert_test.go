@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+func Test_convertBlocks(t *testing.T) {
+	for _, version := range []string{"v1", "v2"} {
+		t.Run(version, func(t *testing.T) {
+			dbPath, err := ioutil.TempDir("", "gitdb-convert")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir failed: %s", err)
+			}
+			defer os.RemoveAll(dbPath)
+
+			src := filepath.Join(packageRoot, "testdata", version, "data", "data", "Message", "b0.json")
+			blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+			data, err := ioutil.ReadFile(src)
+			if err != nil {
+				t.Fatalf("ioutil.ReadFile failed: %s", err)
+			}
+			os.MkdirAll(filepath.Dir(blockFile), 0755)
+			if err := ioutil.WriteFile(blockFile, data, 0744); err != nil {
+				t.Fatalf("ioutil.WriteFile failed: %s", err)
+			}
+
+			want, _ := db.OpenBlock(src, nil)
+			for _, format := range []string{"ndjson", "json"} {
+				if err := convertBlocks(dbPath, format); err != nil {
+					t.Errorf("convertBlocks(%s) failed: %s", format, err)
+				}
+
+				got, err := db.OpenBlock(blockFile, nil)
+				if err != nil {
+					t.Errorf("db.OpenBlock failed: %s", err)
+					continue
+				}
+
+				if string(got.Format()) != format || got.RecordCount() != want.RecordCount() {
+					t.Errorf("want %d records in %s, got: %d records in %s", want.RecordCount(), format, got.RecordCount(), got.Format())
+				}
+
+				for _, record := range want.Records() {
+					if r, err := got.Get(record.ID()); err != nil || r.Data() != record.Data() {
+						t.Errorf("record %s was not converted as is", record.ID())
+					}
+				}
+			}
+
+			converted, _ := ioutil.ReadFile(blockFile)
+			if !bytes.HasPrefix(converted, []byte("{\n\t\"")) {
+				t.Errorf("block was not converted back to json")
+			}
+		})
+	}
+
+	if err := convertBlocks("", "ndjson"); err == nil {
+		t.Errorf("convertBlocks should fail without a path")
+	}
+}
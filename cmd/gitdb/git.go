@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+//checkRepo returns an error if dataDir is not a git repository
+func checkRepo(dataDir string) error {
+	if out, err := exec.Command("git", "-C", dataDir, "rev-parse", "--git-dir").CombinedOutput(); err != nil {
+		return fmt.Errorf("%s is not a git repository: %s", dataDir, out)
+	}
+	return nil
+}
+
+//restore discards uncommitted changes to pathspec in dataDir
+func restore(dataDir, pathspec string) {
+	if out, err := exec.Command("git", "-C", dataDir, "checkout", "--", pathspec).CombinedOutput(); err != nil {
+		fmt.Println(string(out))
+	}
+}
+
+//commit stages all changes to pathspec in dataDir and commits them with msg.
+//It reports whether there was anything to commit
+func commit(dataDir, pathspec, msg string) (bool, error) {
+	if out, err := exec.Command("git", "-C", dataDir, "add", "-A", pathspec).CombinedOutput(); err != nil {
+		return false, fmt.Errorf("git add failed: %s", out)
+	}
+
+	if out, err := exec.Command("git", "-C", dataDir, "diff", "--cached", "--quiet").CombinedOutput(); err == nil {
+		return false, nil
+	} else if len(out) > 0 {
+		return false, fmt.Errorf("git diff failed: %s", out)
+	}
+
+	if out, err := exec.Command("git", "-C", dataDir, "commit", "-m", msg).CombinedOutput(); err != nil {
+		return false, fmt.Errorf("git commit failed: %s", out)
+	}
+
+	return true, nil
+}
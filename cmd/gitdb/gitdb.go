@@ -20,6 +20,21 @@ var (
 	embedCommand = flag.NewFlagSet("embed", flag.ExitOnError)
 	output       = embedCommand.String("o", "./ui_static.go", "output file name; default ./ui_static.go")
 
+	convertCommand = flag.NewFlagSet("convert-blocks", flag.ExitOnError)
+	convertDBPath  = convertCommand.String("p", "", "path to gitdb i.e Config.DBPath")
+	convertFormat  = convertCommand.String("f", "ndjson", "block format to convert to: json or ndjson; default ndjson")
+
+	compactCommand = flag.NewFlagSet("compact", flag.ExitOnError)
+	compactDBPath  = compactCommand.String("p", "", "path to gitdb i.e Config.DBPath")
+	compactDataSet = compactCommand.String("d", "", "dataset to compact")
+	compactMethod  = compactCommand.String("m", "count", "block method: count or size; default count")
+	compactLimit   = compactCommand.Int64("n", 1000, "records (count) or bytes (size) per block; default 1000")
+
+	rotateCommand = flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	rotateDBPath  = rotateCommand.String("p", "", "path to gitdb i.e Config.DBPath")
+	rotateOldKey  = rotateCommand.String("old", os.Getenv("GITDB_OLD_KEY"), "current encryption key; default $GITDB_OLD_KEY")
+	rotateNewKey  = rotateCommand.String("new", os.Getenv("GITDB_NEW_KEY"), "new encryption key; default $GITDB_NEW_KEY")
+
 	// dbpath      = flag.String("p", "", "path do gitdb")
 )
 
@@ -33,8 +48,23 @@ func main() {
 		if err != nil {
 			fmt.Println(err.Error())
 		}
+	case "convert-blocks":
+		convertCommand.Parse(os.Args[2:])
+		if err := convertBlocks(*convertDBPath, *convertFormat); err != nil {
+			fmt.Println(err.Error())
+		}
+	case "compact":
+		compactCommand.Parse(os.Args[2:])
+		if err := compactDataset(*compactDBPath, *compactDataSet, *compactMethod, *compactLimit); err != nil {
+			fmt.Println(err.Error())
+		}
+	case "rotate-key":
+		rotateCommand.Parse(os.Args[2:])
+		if err := rotateKey(*rotateDBPath, *rotateOldKey, *rotateNewKey); err != nil {
+			fmt.Println(err.Error())
+		}
 	default:
-		fmt.Println("invalid command; try gitdb embed-ui")
+		fmt.Println("invalid command; try gitdb embed-ui, gitdb convert-blocks, gitdb compact or gitdb rotate-key")
 		//future commands
 		//clean-db i.e git gc
 		//repair
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//rotateKey re-encrypts all encrypted records of the gitdb at dbPath from oldKey
+//to newKey and commits the result. Running it again after an interruption resumes it
+func rotateKey(dbPath, oldKey, newKey string) error {
+	if len(dbPath) == 0 {
+		return errors.New("path to gitdb must be set with -p")
+	}
+
+	if len(newKey) == 0 || oldKey == newKey {
+		return errors.New("new encryption key must be set and differ from the old one")
+	}
+
+	dataDir := filepath.Join(dbPath, "data")
+	if err := checkRepo(dataDir); err != nil {
+		return err
+	}
+
+	rotated, err := db.RotateKey(dataDir, crypto.StaticKey(oldKey), crypto.StaticKey(newKey), nil)
+	for _, blockFile := range rotated {
+		fmt.Println("rotated " + blockFile)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s; fix the error and run rotate-key again to resume", err)
+	}
+
+	//record positions in the index are stale
+	if len(rotated) > 0 {
+		if err := os.RemoveAll(filepath.Join(dbPath, ".gitdb", "index")); err != nil {
+			return err
+		}
+	}
+
+	if _, err := commit(dataDir, ".", "Rotating encryption key"); err != nil {
+		return err
+	}
+
+	fmt.Println("encryption key rotated; set Config.EncryptionKey to the new key")
+	return nil
+}
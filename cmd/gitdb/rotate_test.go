@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+func Test_rotateKey(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "gitdb-rotate")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dbPath)
+
+	oldKey := "b61ba8270ccc3c1d42b4417e7bd60b71"
+	newKey := "0123456789abcdef0123456789abcdef"
+
+	dataDir := filepath.Join(dbPath, "data")
+	git := func(args ...string) {
+		args = append([]string{"-C", dataDir}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s", args, out)
+		}
+	}
+
+	enc, err := crypto.Encrypt(oldKey, "", `{"MessageId":1}`)
+	if err != nil {
+		t.Fatalf("crypto.Encrypt failed: %s", err)
+	}
+	data, _ := json.Marshal(map[string]string{"Message/b0/1": enc, "Message/b0/2": `{"MessageId":2}`})
+	blockFile := filepath.Join(dataDir, "Message", "b0.json")
+	os.MkdirAll(filepath.Dir(blockFile), 0755)
+	if err := ioutil.WriteFile(blockFile, data, 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	git("init", "-q")
+	git("config", "user.name", "Tester")
+	git("config", "user.email", "tester@io")
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+
+	if err := rotateKey(dbPath, oldKey, oldKey); err == nil {
+		t.Errorf("rotateKey should fail if the keys are the same")
+	}
+
+	if err := rotateKey(dbPath, oldKey, newKey); err != nil {
+		t.Fatalf("rotateKey failed: %s", err)
+	}
+
+	block, err := db.OpenBlock(blockFile, crypto.StaticKey(newKey))
+	if err != nil {
+		t.Fatalf("db.OpenBlock failed: %s", err)
+	}
+
+	for _, id := range []string{"Message/b0/1", "Message/b0/2"} {
+		record, err := block.Get(id)
+		if err != nil {
+			t.Errorf("block.Get(%s) failed: %s", id, err)
+			continue
+		}
+
+		var m map[string]interface{}
+		if err := record.Hydrate(&m); err != nil {
+			t.Errorf("%s does not decrypt with the new key: %s", id, err)
+		}
+	}
+
+	out, err := exec.Command("git", "-C", dataDir, "status", "--porcelain").CombinedOutput()
+	if err != nil || len(out) > 0 {
+		t.Errorf("rotation should be committed, got: %s", out)
+	}
+}
@@ -0,0 +1,151 @@
+package gitdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//CompactPolicy sets how Compact sizes the blocks of a dataset.
+//Limit is a number of records for BlockByCount and a number of bytes for BlockBySize
+type CompactPolicy struct {
+	Method BlockMethod
+	Limit  int64
+}
+
+func (p CompactPolicy) internal() (db.CompactPolicy, error) {
+	if p.Limit <= 0 {
+		return db.CompactPolicy{}, errors.New("compact policy limit must be greater than 0")
+	}
+
+	switch p.Method {
+	case BlockByCount:
+		return db.CompactPolicy{MaxRecords: int(p.Limit)}, nil
+	case BlockBySize:
+		return db.CompactPolicy{MaxBytes: p.Limit}, nil
+	}
+
+	return db.CompactPolicy{}, fmt.Errorf("invalid compact method: %s", p.Method)
+}
+
+//Compact rewrites dataset into blocks b0, b1, ... sized by policy, rebuilds its
+//indexes and commits the result. It returns the old and new ids of moved records.
+//Compact suits datasets whose block ids come from AutoBlock: it fails and leaves the
+//dataset as it was if a moved record's model still gives its old id, e.g. because
+//GetSchema derives the block from the record's data
+func (g *gitdb) Compact(dataset string, policy CompactPolicy) (map[string]string, error) {
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	p, err := policy.internal()
+	if err != nil {
+		return nil, err
+	}
+
+	defer g.enterWrite(context.Background())()
+
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	log.Info("compacting dataset: " + dataset)
+	datasetPath := filepath.Join(g.dbDir(), dataset)
+
+	//journal the dataset's block and index files so that
+	//a failed compaction puts back only what it changed
+	j := newJournal()
+	g.indexMu.Lock()
+	indexFiles := append(g.indexFiles(dataset), g.textIndexFiles(dataset)...)
+	g.indexMu.Unlock()
+	for _, indexFile := range indexFiles {
+		if err := j.record(indexFile); err != nil {
+			return nil, err
+		}
+	}
+
+	moved, err := db.Compact(datasetPath, p, db.Format(g.config.BlockFormat), j.record)
+	g.loadedBlocks = nil
+	if err == nil {
+		err = g.checkCompacted(dataset, moved)
+	}
+	if err == nil {
+		err = g.Reindex(dataset)
+	}
+	if err != nil {
+		g.undoCompact(dataset, j)
+		return nil, err
+	}
+
+	//commit all rewritten and removed blocks together
+	e := newWriteEvent("Compacting "+dataset, datasetPath, true)
+	g.commit.Add(1)
+	g.events <- e
+	g.waitForCommit(e)
+
+	return moved, nil
+}
+
+//checkCompacted returns an error if the registered model of dataset
+//does not give a moved record its new id
+func (g *gitdb) checkCompacted(dataset string, moved map[string]string) error {
+	blocks := map[string]*db.Block{}
+	for oldID, newID := range moved {
+		_, blockID, _, err := ParseID(newID)
+		if err != nil {
+			return err
+		}
+
+		blockFile := g.blockFilePath(dataset, blockID)
+		block, ok := blocks[blockFile]
+		if !ok {
+			if block, err = db.OpenBlock(blockFile, g.config.keys()); err != nil {
+				return err
+			}
+			blocks[blockFile] = block
+		}
+
+		record, err := block.Get(newID)
+		if err != nil {
+			return err
+		}
+
+		m, err := newModel(g.model(dataset))
+		if err != nil {
+			return err
+		}
+		if err := record.Hydrate(m); err != nil {
+			return err
+		}
+
+		if ID(m) != newID {
+			return fmt.Errorf("cannot compact %s: %s moved to %s but its model gives %s; "+
+				"compact only datasets whose blocks come from AutoBlock", dataset, oldID, newID, ID(m))
+		}
+	}
+
+	return nil
+}
+
+//undoCompact restores the block and index files of dataset recorded in j
+//and drops its cached indexes so they are rebuilt from the restored blocks.
+//g.writeMu must be held
+func (g *gitdb) undoCompact(dataset string, j *journal) {
+	if err := g.rollback(j); err != nil {
+		log.Error(err.Error())
+	}
+
+	g.indexMu.Lock()
+	for _, indexFile := range g.indexFiles(dataset) {
+		delete(g.indexCache, indexFile)
+		delete(g.positionCache, indexFile)
+	}
+	for _, indexFile := range g.textIndexFiles(dataset) {
+		delete(g.textIndexCache, indexFile)
+	}
+	delete(g.indexedSets, dataset)
+	g.indexMu.Unlock()
+}
@@ -0,0 +1,201 @@
+package gitdb_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+type Ticket struct {
+	gitdb.TimeStampedModel
+	Block    string
+	TicketNo int
+	Status   string
+}
+
+func (t *Ticket) GetSchema() *gitdb.Schema {
+	indexes := make(map[string]interface{})
+	indexes["Status"] = t.Status
+
+	return gitdb.NewSchema("Ticket", t.Block, fmt.Sprintf("%d", t.TicketNo), indexes)
+}
+
+func (t *Ticket) Validate() error     { return nil }
+func (t *Ticket) IsLockable() bool    { return false }
+func (t *Ticket) ShouldEncrypt() bool { return false }
+func (t *Ticket) GetLockFileNames() []string {
+	return []string{}
+}
+
+//Job finds its block with AutoBlock rather than storing it
+type Job struct {
+	gitdb.TimeStampedModel
+	JobNo  int
+	Status string
+	block  string
+}
+
+func (j *Job) GetSchema() *gitdb.Schema {
+	if len(j.block) == 0 {
+		//AutoBlock only needs the dataset and record id from the schema
+		j.block = "-"
+		j.block = gitdb.AutoBlock(dbPath, j, gitdb.BlockByCount, 3)
+	}
+
+	indexes := make(map[string]interface{})
+	indexes["Status"] = j.Status
+
+	return gitdb.NewSchema("Job", j.block, strconv.Itoa(j.JobNo), indexes)
+}
+
+func (j *Job) Validate() error     { return nil }
+func (j *Job) IsLockable() bool    { return false }
+func (j *Job) ShouldEncrypt() bool { return false }
+func (j *Job) GetLockFileNames() []string {
+	return []string{}
+}
+
+func TestCompact(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Job", &Job{})
+
+	//12 jobs in blocks b0 to b3 with every other job deleted
+	var jobs []*Job
+	for i := 0; i < 12; i++ {
+		job := &Job{JobNo: i, Status: "open"}
+		if err := testDb.Insert(job); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+		jobs = append(jobs, job)
+	}
+	for i := 0; i < 12; i += 2 {
+		if err := testDb.Delete(gitdb.ID(jobs[i])); err != nil {
+			t.Errorf("testDb.Delete failed: %s", err)
+		}
+	}
+
+	if _, err := testDb.Compact("Job", gitdb.CompactPolicy{Method: gitdb.BlockByCount}); err == nil {
+		t.Errorf("testDb.Compact should fail without a limit")
+	}
+
+	moved, err := testDb.Compact("Job", gitdb.CompactPolicy{Method: gitdb.BlockByCount, Limit: 4})
+	if err != nil {
+		t.Fatalf("testDb.Compact failed: %s", err)
+	}
+
+	want := map[string]string{
+		"Job/b1/3":  "Job/b0/3",
+		"Job/b1/5":  "Job/b0/5",
+		"Job/b2/7":  "Job/b0/7",
+		"Job/b3/9":  "Job/b1/9",
+		"Job/b3/11": "Job/b1/11",
+	}
+	if fmt.Sprint(moved) != fmt.Sprint(want) {
+		t.Errorf("want moved: %v, got: %v", want, moved)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dbPath, "data", "Job"))
+	var blocks []string
+	for _, file := range files {
+		blocks = append(blocks, file.Name())
+	}
+	if strings.Join(blocks, ",") != "b0.json,b1.json" {
+		t.Errorf("want blocks b0.json,b1.json, got: %v", blocks)
+	}
+
+	for oldID, newID := range moved {
+		var job Job
+		if err := testDb.Get(newID, &job); err != nil {
+			t.Errorf("testDb.Get(%s) failed: %s", newID, err)
+		}
+		if err := testDb.Exists(oldID); err == nil {
+			t.Errorf("%s should not exist after compaction", oldID)
+		}
+	}
+
+	//an update is saved to the record's new block
+	job := &Job{JobNo: 9, Status: "closed"}
+	if err := testDb.Insert(job); err != nil || gitdb.ID(job) != "Job/b1/9" {
+		t.Errorf("testDb.Insert want: Job/b1/9, got: %s (%v)", gitdb.ID(job), err)
+	}
+
+	n, err := testDb.Count("Job", gitdb.Where("Status", gitdb.SearchEquals, "open"))
+	if err != nil || n != 5 {
+		t.Errorf("testDb.Count want: 5, got: %d (%v)", n, err)
+	}
+
+	report, err := testDb.VerifyIndexes("Job")
+	if err != nil || !report.OK() {
+		t.Errorf("indexes should be consistent after compaction, got: %+v (%v)", report, err)
+	}
+
+	out, err := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "status", "--porcelain").CombinedOutput()
+	if err != nil || len(out) > 0 {
+		t.Errorf("compaction should be committed, got: %s", out)
+	}
+}
+
+func TestCompactDataBlock(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Ticket", &Ticket{})
+
+	//Ticket stores its block so a compacted ticket would be saved back to its old block
+	var tickets []gitdb.Model
+	for i := 0; i < 6; i++ {
+		tickets = append(tickets, &Ticket{Block: fmt.Sprintf("b%d", i/2), TicketNo: i, Status: "open"})
+	}
+	if err := testDb.InsertMany(tickets); err != nil {
+		t.Fatalf("testDb.InsertMany failed: %s", err)
+	}
+
+	blockDir := filepath.Join(dbPath, "data", "Ticket")
+	before := map[string]string{}
+	files, _ := ioutil.ReadDir(blockDir)
+	for _, file := range files {
+		data, _ := ioutil.ReadFile(filepath.Join(blockDir, file.Name()))
+		before[file.Name()] = string(data)
+	}
+
+	//an unrelated change in the working tree must survive a failed compaction
+	unrelated := filepath.Join(dbPath, "data", "notes.txt")
+	if err := ioutil.WriteFile(unrelated, []byte("keep me"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	if _, err := testDb.Compact("Ticket", gitdb.CompactPolicy{Method: gitdb.BlockByCount, Limit: 10}); err == nil {
+		t.Fatal("testDb.Compact should refuse a dataset whose blocks come from record data")
+	}
+
+	after := map[string]string{}
+	files, _ = ioutil.ReadDir(blockDir)
+	for _, file := range files {
+		data, _ := ioutil.ReadFile(filepath.Join(blockDir, file.Name()))
+		after[file.Name()] = string(data)
+	}
+	if fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("blocks should be restored after a failed compaction, got: %v", after)
+	}
+
+	if data, err := ioutil.ReadFile(unrelated); err != nil || string(data) != "keep me" {
+		t.Errorf("unrelated file should be kept, got: %q (%v)", data, err)
+	}
+
+	for _, ticket := range tickets {
+		if err := testDb.Exists(gitdb.ID(ticket)); err != nil {
+			t.Errorf("testDb.Exists(%s) failed: %s", gitdb.ID(ticket), err)
+		}
+	}
+
+	n, err := testDb.Count("Ticket", gitdb.Where("Status", gitdb.SearchEquals, "open"))
+	if err != nil || n != 6 {
+		t.Errorf("testDb.Count want: 6, got: %d (%v)", n, err)
+	}
+}
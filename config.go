@@ -3,6 +3,8 @@ package gitdb
 import (
 	"errors"
 	"time"
+
+	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
 // Config represents configuration options for GitDB
@@ -16,11 +18,35 @@ type Config struct {
 	Factory        func(string) Model
 	EnableUI       bool
 	UIPort         int
+	// KeyProvider supplies encryption keys per dataset and key id. It replaces EncryptionKey
+	KeyProvider KeyProvider
+	// IndexKey keys the blind indexes of encrypted fields and must not change once records are indexed.
+	// It is required to index encrypted fields with a KeyProvider. If empty, EncryptionKey is used
+	// and RotateKey rebuilds blind indexes
+	IndexKey string
+	// BlockFormat is the format block files are written in.
+	// If empty, blocks keep the format they were read in and new blocks are BlockFormatJSON
+	BlockFormat BlockFormat
+	// Compression switches gzip compression of block files on (true) or off (false) per dataset.
+	// Blocks of datasets not listed keep the compression they were read with.
+	// Compressed and uncompressed blocks are always readable
+	Compression map[string]bool
 	// Mock is a hook for testing apps. If true will return a Mock DB connection
 	Mock   bool
 	Driver dbDriver
 }
 
+// BlockFormat is the on-disk layout of block files. Blocks in either format are always readable
+type BlockFormat string
+
+const (
+	// BlockFormatJSON writes a block as a JSON object of record id to escaped record
+	BlockFormatJSON BlockFormat = BlockFormat(db.FormatJSON)
+	// BlockFormatNDJSON writes a block with one unescaped record per line sorted by id
+	// which keeps git diffs readable and lets git merge changes to different records
+	BlockFormatNDJSON BlockFormat = BlockFormat(db.FormatNDJSON)
+)
+
 const defaultConnectionName = "default"
 const defaultSyncInterval = time.Second * 5
 const defaultUserName = "ghost"
@@ -57,5 +83,13 @@ func (c *Config) Validate() error {
 		return errors.New("Config.DbPath must be set")
 	}
 
+	if len(c.EncryptionKey) > 0 && c.KeyProvider != nil {
+		return errors.New("Config.EncryptionKey and Config.KeyProvider cannot both be set")
+	}
+
+	if len(c.BlockFormat) > 0 && c.BlockFormat != BlockFormatJSON && c.BlockFormat != BlockFormatNDJSON {
+		return errors.New("Config.BlockFormat must be json or ndjson")
+	}
+
 	return nil
 }
@@ -0,0 +1,64 @@
+package gitdb
+
+import "github.com/gogitdb/gitdb/v2/internal/db"
+
+//Cursor iterates over the records of a dataset one block at a time.
+//Only the block being iterated is held in memory
+//
+//	cursor, err := db.Iterate("Booking")
+//	for cursor.Next() {
+//		record := cursor.Record()
+//	}
+//	if err := cursor.Err(); err != nil {
+//		...
+//	}
+type Cursor struct {
+	blocks  []string
+	load    func(block string) ([]*db.Record, error)
+	records []*db.Record
+	record  *db.Record
+	err     error
+}
+
+//newCursor constructs a *Cursor which calls load for each block in turn
+func newCursor(blocks []string, load func(block string) ([]*db.Record, error)) *Cursor {
+	return &Cursor{blocks: blocks, load: load}
+}
+
+//Next advances the cursor to the next record. It returns false when
+//there are no more records or an error occurred
+func (c *Cursor) Next() bool {
+	c.record = nil
+	for len(c.records) == 0 {
+		if c.err != nil || len(c.blocks) == 0 {
+			return false
+		}
+
+		c.records, c.err = c.load(c.blocks[0])
+		c.blocks = c.blocks[1:]
+	}
+
+	c.record = c.records[0]
+	//release record so it can be garbage collected once iterated
+	c.records[0] = nil
+	c.records = c.records[1:]
+	return true
+}
+
+//Record returns the current record
+func (c *Cursor) Record() *db.Record {
+	return c.record
+}
+
+//Err returns the error, if any, that stopped the iteration
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+//Close stops the iteration and releases any loaded records
+func (c *Cursor) Close() error {
+	c.blocks = nil
+	c.records = nil
+	c.record = nil
+	return nil
+}
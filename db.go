@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
@@ -26,35 +27,79 @@ const (
 	SearchStartsWith SearchMode = 3
 	// SearchEndsWith will search index for records whose values ends with SearchParam.Value
 	SearchEndsWith SearchMode = 4
+	// SearchGreaterThan will search index for records whose values are greater than SearchParam.Value
+	SearchGreaterThan SearchMode = 5
+	// SearchGreaterThanOrEquals will search index for records whose values are greater than or equal to SearchParam.Value
+	SearchGreaterThanOrEquals SearchMode = 6
+	// SearchLessThan will search index for records whose values are less than SearchParam.Value
+	SearchLessThan SearchMode = 7
+	// SearchLessThanOrEquals will search index for records whose values are less than or equal to SearchParam.Value
+	SearchLessThanOrEquals SearchMode = 8
+	// SearchBetween will search index for records whose values are between SearchParam.Value and SearchParam.To inclusive
+	SearchBetween SearchMode = 9
+	// SearchNotEquals will search index for records whose values do not equal SearchParam.Value
+	SearchNotEquals SearchMode = 10
+	// SearchRegex will search index for records whose values match the regular expression in SearchParam.Value
+	SearchRegex SearchMode = 11
+	// SearchGlob will search index for records whose values match the wildcard pattern in SearchParam.Value
+	// where * matches any run of characters and ? matches a single character
+	SearchGlob SearchMode = 12
 )
 
 // SearchParam represents search parameters against GitDB index
+// Value (and To for SearchBetween) can be a string, a number or a time.Time and decides how index
+// values are compared: numbers numerically, times chronologically and strings as strings, so "10"
+// is less than "9". String comparisons ignore case unless CaseSensitive is set
 type SearchParam struct {
-	Index string
-	Value string
+	Index         string
+	Value         interface{}
+	To            interface{}
+	CaseSensitive bool
 }
 
 // GitDb interface defines all exported funcs an implementation must have
 type GitDb interface {
 	Close() error
 	Insert(m Model) error
+	InsertContext(ctx context.Context, m Model) error
 	InsertMany(m []Model) error
+	UpdateIf(m Model, expectedRevision int64) error
+	UpdateIfContext(ctx context.Context, m Model, expectedRevision int64) error
 	Get(id string, m Model) error
+	GetContext(ctx context.Context, id string, m Model) error
+	GetWithRevision(id string, m Model) (int64, error)
+	GetWithRevisionContext(ctx context.Context, id string, m Model) (int64, error)
 	Exists(id string) error
 	Fetch(dataset string, block ...string) ([]*db.Record, error)
+	FetchContext(ctx context.Context, dataset string, block ...string) ([]*db.Record, error)
+	Iterate(dataset string, block ...string) (*Cursor, error)
 	Search(dataDir string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error)
+	SearchContext(ctx context.Context, dataDir string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error)
+	SearchText(dataset string, index string, text string) ([]*db.Record, error)
+	Find(dataset string, q *Query) ([]*db.Record, error)
+	Count(dataset string, q *Query) (int, error)
+	GroupBy(dataset string, index string, q *Query) (map[string]int, error)
+	Sum(dataset string, index string, q *Query) (float64, error)
+	Min(dataset string, index string, q *Query) (float64, error)
+	Max(dataset string, index string, q *Query) (float64, error)
 	Delete(id string) error
+	DeleteContext(ctx context.Context, id string) error
 	DeleteOrFail(id string) error
 	Lock(m Model) error
 	Unlock(m Model) error
 	Upload() *Upload
 	Migrate(from Model, to Model) error
+	VerifyIndexes(dataset string) (*IndexReport, error)
+	Reindex(dataset string) error
+	Compact(dataset string, policy CompactPolicy) (map[string]string, error)
+	RotateKey(oldKey, newKey string) error
 	GetMails() []*mail
 	StartTransaction(name string) Transaction
 	GetLastCommitTime() (time.Time, error)
 	SetUser(user *User) error
 	Config() Config
 	Sync() error
+	SyncContext(ctx context.Context) error
 	RegisterModel(dataset string, m Model) bool
 }
 
@@ -63,6 +108,7 @@ type gitdb struct {
 	indexMu  sync.Mutex
 	writeMu  sync.Mutex
 	syncMu   sync.Mutex
+	opGate   sync.RWMutex //held by writes for reading and by the operations of a transaction for writing
 	commit   sync.WaitGroup
 	locked   chan bool
 	shutdown chan bool
@@ -71,28 +117,36 @@ type gitdb struct {
 	config Config
 	driver dbDriver
 
-	autoCommit   bool
 	indexUpdated bool
 	loopStarted  bool
 	closed       bool
 
-	indexCache   gdbSimpleIndexCache
-	loadedBlocks map[string]*db.Block
+	indexCache     gdbSimpleIndexCache
+	textIndexCache gdbTextIndexCache
+	positionCache  gdbIndexCache
+	loadedBlocks   map[string]*db.Block
+	indexedSets    map[string]bool //datasets whose indexes have been built
+	blockMetas     *blockMetaCache
+	opJournal      *journal //journal of the running transaction operations, guarded by writeMu
 
 	mails    []*mail
 	registry map[string]Model
 }
 
 func newConnection() *gitdb {
-	// autocommit defaults to true
-	db := &gitdb{autoCommit: true, indexCache: make(gdbSimpleIndexCache)}
+	db := &gitdb{
+		indexCache:     make(gdbSimpleIndexCache),
+		textIndexCache: make(gdbTextIndexCache),
+		positionCache:  make(gdbIndexCache),
+		indexedSets:    make(map[string]bool),
+		blockMetas:     newBlockMetaCache(),
+	}
 	// initialize channels
 	db.events = make(chan *dbEvent, 1)
 	db.locked = make(chan bool, 1)
-	// initialize shutdown channel with capacity 3
-	// to represent the event loop, sync clock, UI server
-	// goroutines
-	db.shutdown = make(chan bool, 3)
+	// shutdown is closed by Close to stop the event loop,
+	// sync clock and UI server goroutines together
+	db.shutdown = make(chan bool)
 
 	return db
 }
@@ -119,12 +173,14 @@ func (g *gitdb) Close() error {
 		return err
 	}
 
-	// send shutdown event to event loop and sync clock
-	g.shutdown <- true
-	g.waitForCommit()
+	// let pending writes commit then stop the event loop, sync clock and UI server
+	g.commit.Wait()
+	close(g.shutdown)
 
 	// remove cached connection
+	connsMu.Lock()
 	delete(conns, g.config.ConnectionName)
+	connsMu.Unlock()
 	g.closed = true
 	log.Info("closed gitdb conn")
 	return nil
@@ -160,12 +216,13 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 		return errors.New("Invalid migration - no change found in schema")
 	}*/
 
-	block := db.NewEmptyBlock(g.config.EncryptionKey)
-	if err := g.doFetch(from.GetSchema().name(), block); err != nil {
+	block := db.NewEmptyBlock(g.config.keys())
+	if err := g.doFetch(context.Background(), from.GetSchema().name(), block); err != nil {
 		return err
 	}
 
 	oldBlocks := map[string]string{}
+	oldRecords := map[string]string{}
 	var migrate []Model
 	for _, record := range block.Records() {
 		dataset, blockID, _, _ := ParseID(record.ID())
@@ -173,12 +230,19 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 			blockFilePath := filepath.Join(g.dbDir(), dataset, blockID+".json")
 			oldBlocks[blockID] = blockFilePath
 		}
+		oldRecords[record.ID()] = blockID
 
-		if err := record.Hydrate(to); err != nil {
+		//hydrate a fresh model for each record so that every
+		//record is migrated rather than the last one repeatedly
+		m, err := newModel(to)
+		if err != nil {
+			return err
+		}
+		if err := record.Hydrate(m); err != nil {
 			return err
 		}
 
-		migrate = append(migrate, to)
+		migrate = append(migrate, m)
 	}
 
 	// InsertMany will rollback if any insert fails
@@ -186,6 +250,16 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 		return err
 	}
 
+	//keep block files that were rewritten by the migration
+	for _, m := range migrate {
+		schema := m.GetSchema()
+		for blockID, blockFilePath := range oldBlocks {
+			if blockFilePath == g.blockFilePath(schema.name(), schema.block) {
+				delete(oldBlocks, blockID)
+			}
+		}
+	}
+
 	// remove all old block files
 	for _, blockFilePath := range oldBlocks {
 		log.Info("Removing old block: " + blockFilePath)
@@ -195,6 +269,14 @@ func (g *gitdb) Migrate(from Model, to Model) error {
 		}
 	}
 
+	var removed []string
+	for recordID, blockID := range oldRecords {
+		if _, ok := oldBlocks[blockID]; ok {
+			removed = append(removed, recordID)
+		}
+	}
+	g.removeFromIndexes(from.GetSchema().name(), removed...)
+
 	return nil
 }
 
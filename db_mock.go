@@ -1,37 +1,80 @@
 package gitdb
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2/internal/db"
+	"github.com/gogitdb/gitdb/v2/internal/fts"
 )
 
 type mockdb struct {
-	config Config
-	data   map[string]Model
-	index  map[string]map[string]interface{}
-	locks  map[string]bool
+	config    Config
+	data      map[string]Model
+	index     map[string]map[string]interface{}
+	textIndex map[string]*gdbTextIndex
+	locks     map[string]bool
+	revisions map[string]int64
 }
 
 type mocktransaction struct {
 	name       string
 	operations []operation
 	db         *mockdb
+	buffer     *txBuffer
+	locks      []Model
+	unlocks    []Model
 }
 
 func (t *mocktransaction) Commit() error {
+	return t.CommitContext(context.Background())
+}
+
+func (t *mocktransaction) CommitContext(ctx context.Context) error {
 	for _, o := range t.operations {
-		if err := o(); err != nil {
+		if err := o(ctx); err != nil {
 			log.Info("Reverting transaction: " + err.Error())
 			return err
 		}
 	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for recordID, r := range t.buffer.records {
+		if err := t.db.Insert(r.model); err != nil {
+			return fmt.Errorf("%s: %w", recordID, err)
+		}
+	}
+
+	for recordID := range t.buffer.deleted {
+		if err := t.db.Delete(recordID); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range t.locks {
+		if err := t.db.Lock(m); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range t.unlocks {
+		if err := t.db.Unlock(m); err != nil {
+			return err
+		}
+	}
+
+	t.buffer = newTxBuffer()
+	t.locks, t.unlocks = nil, nil
 	return nil
 }
 
@@ -39,11 +82,99 @@ func (t *mocktransaction) AddOperation(o operation) {
 	t.operations = append(t.operations, o)
 }
 
+func (t *mocktransaction) Insert(m Model) error {
+	if err := t.buffer.checkUnique(m); err != nil {
+		return err
+	}
+
+	r, err := newTxRecord(m)
+	if err != nil {
+		return err
+	}
+
+	t.buffer.insert(ID(m), r)
+	return nil
+}
+
+func (t *mocktransaction) Update(id string, fn func(m Model) error) error {
+	var existing Model
+	if r, ok := t.buffer.records[id]; ok {
+		existing = r.model
+	} else if m, ok := t.db.data[id]; ok && !t.buffer.deleted[id] {
+		existing = m
+	} else {
+		return ErrRecordNotFound
+	}
+
+	m, err := newModel(existing)
+	if err != nil {
+		return err
+	}
+	if err := t.Get(id, m); err != nil {
+		return err
+	}
+
+	if err := fn(m); err != nil {
+		return err
+	}
+
+	if ID(m) != id {
+		return fmt.Errorf("Update of %s cannot change its id to %s", id, ID(m))
+	}
+
+	return t.Insert(m)
+}
+
+func (t *mocktransaction) Lock(m Model) error {
+	if _, ok := m.(LockableModel); !ok {
+		return errors.New("Model is not lockable")
+	}
+	//locking a model this transaction unlocks keeps it as it is
+	for i, u := range t.unlocks {
+		if ID(u) == ID(m) {
+			t.unlocks = append(t.unlocks[:i], t.unlocks[i+1:]...)
+			return nil
+		}
+	}
+	t.locks = append(t.locks, m)
+	return nil
+}
+
+func (t *mocktransaction) Unlock(m Model) error {
+	if _, ok := m.(LockableModel); !ok {
+		return errors.New("Model is not lockable")
+	}
+	t.unlocks = append(t.unlocks, m)
+	return nil
+}
+
+func (t *mocktransaction) Delete(id string) error {
+	t.buffer.delete(id)
+	return nil
+}
+
+func (t *mocktransaction) Get(id string, result Model) error {
+	if found, err := t.buffer.get(id, result); found || err != nil {
+		return err
+	}
+	return t.db.Get(id, result)
+}
+
+func (t *mocktransaction) Search(dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	committed, err := t.db.Search(dataset, searchParams, searchMode)
+	if err != nil {
+		return nil, err
+	}
+	return t.buffer.search(dataset, searchQuery(searchParams, searchMode), committed)
+}
+
 func newMockConnection() *mockdb {
 	db := &mockdb{
-		data:  make(map[string]Model),
-		index: make(map[string]map[string]interface{}),
-		locks: make(map[string]bool),
+		data:      make(map[string]Model),
+		index:     make(map[string]map[string]interface{}),
+		textIndex: make(map[string]*gdbTextIndex),
+		locks:     make(map[string]bool),
+		revisions: make(map[string]int64),
 	}
 	return db
 }
@@ -54,22 +185,72 @@ func (g *mockdb) Close() error {
 }
 
 func (g *mockdb) Insert(m Model) error {
+	schema := schemaOf(m)
+	for name := range schema.unique {
+		value := schema.indexes[name]
+		if isEmptyIndexValue(value) {
+			continue
+		}
+		for id, dbValue := range g.index[schema.dataset+"."+name] {
+			if id != ID(m) && matchIndexValue(dbValue, &SearchParam{Value: value}, SearchEquals) {
+				return uniqueViolation(schema.dataset, name, value, id)
+			}
+		}
+	}
+
 	g.data[ID(m)] = m
+	g.revisions[ID(m)]++
 
-	for name, value := range m.GetSchema().indexes {
-		key := m.GetSchema().dataset + "." + name
+	for name, value := range schema.indexes {
+		key := schema.dataset + "." + name
 		if _, ok := g.index[key]; !ok {
 			g.index[key] = make(map[string]interface{})
 		}
 		g.index[key][ID(m)] = value
 	}
 
+	for name, text := range schema.fullText {
+		key := schema.dataset + "." + name
+		if _, ok := g.textIndex[key]; !ok {
+			g.textIndex[key] = newTextIndex()
+		}
+		g.textIndex[key].add(ID(m), fts.Tokenize(text))
+	}
+
 	return nil
 }
 
+func (g *mockdb) UpdateIf(m Model, expectedRevision int64) error {
+	if expectedRevision < 0 {
+		return fmt.Errorf("invalid revision: %d", expectedRevision)
+	}
+
+	if revision := g.revisions[ID(m)]; revision != expectedRevision {
+		return fmt.Errorf("%w: %s is at revision %d not %d", ErrConflict, ID(m), revision, expectedRevision)
+	}
+
+	return g.Insert(m)
+}
+
+func (g *mockdb) UpdateIfContext(ctx context.Context, m Model, expectedRevision int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.UpdateIf(m, expectedRevision)
+}
+
+func (g *mockdb) InsertContext(ctx context.Context, m Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Insert(m)
+}
+
 func (g *mockdb) InsertMany(m []Model) error {
 	for _, model := range m {
-		g.Insert(model)
+		if err := g.Insert(model); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -100,6 +281,28 @@ func (g *mockdb) Get(id string, result Model) error {
 	return fmt.Errorf("Record %s not found in %s", id, dataset)
 }
 
+func (g *mockdb) GetContext(ctx context.Context, id string, result Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Get(id, result)
+}
+
+func (g *mockdb) GetWithRevision(id string, result Model) (int64, error) {
+	if err := g.Get(id, result); err != nil {
+		return 0, err
+	}
+
+	return g.revisions[id], nil
+}
+
+func (g *mockdb) GetWithRevisionContext(ctx context.Context, id string, result Model) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return g.GetWithRevision(id, result)
+}
+
 func (g *mockdb) Exists(id string) error {
 	_, exists := g.data[id]
 	if !exists {
@@ -134,47 +337,248 @@ func (g *mockdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
 	return result, nil
 }
 
+func (g *mockdb) Iterate(dataset string, blocks ...string) (*Cursor, error) {
+	if len(blocks) == 0 {
+		seen := map[string]bool{}
+		for _, id := range g.ids(dataset) {
+			if _, b, _, err := ParseID(id); err == nil && !seen[b] {
+				seen[b] = true
+				blocks = append(blocks, b)
+			}
+		}
+	}
+
+	return newCursor(blocks, func(block string) ([]*db.Record, error) {
+		return g.Fetch(dataset, block)
+	}), nil
+}
+
+func (g *mockdb) FetchContext(ctx context.Context, dataset string, blocks ...string) ([]*db.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.Fetch(dataset, blocks...)
+}
+
+func (g *mockdb) SearchContext(ctx context.Context, dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return g.Search(dataset, searchParams, searchMode)
+}
+
 func (g *mockdb) Search(dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	return g.Find(dataset, searchQuery(searchParams, searchMode))
+}
+
+func (g *mockdb) SearchText(dataset, name, text string) ([]*db.Record, error) {
 	result := []*db.Record{}
-	for _, searchParam := range searchParams {
-		key := dataset + "." + searchParam.Index
-
-		queryValue := strings.ToLower(searchParam.Value)
-		for recordID, value := range g.index[key] {
-			addResult := false
-			dbValue := strings.ToLower(value.(string))
-			switch searchMode {
-			case SearchEquals:
-				addResult = dbValue == queryValue
-			case SearchContains:
-				addResult = strings.Contains(dbValue, queryValue)
-			case SearchStartsWith:
-				addResult = strings.HasPrefix(dbValue, queryValue)
-			case SearchEndsWith:
-				addResult = strings.HasSuffix(dbValue, queryValue)
-			}
+	index, ok := g.textIndex[dataset+"."+name]
+	if !ok {
+		return result, nil
+	}
 
-			if addResult {
-				result = append(result, db.ConvertModel(recordID, g.data[recordID]))
-			}
+	for _, recordID := range index.search(fts.Tokenize(text)) {
+		if model, ok := g.data[recordID]; ok {
+			result = append(result, db.ConvertModel(recordID, model))
 		}
 	}
 
 	return result, nil
 }
 
+func (g *mockdb) Find(dataset string, q *Query) ([]*db.Record, error) {
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*db.Record{}
+	for _, recordID := range recordIDs {
+		result = append(result, db.ConvertModel(recordID, g.data[recordID]))
+	}
+
+	return result, nil
+}
+
+//queryIndex returns the ids of records in dataset that satisfy q
+func (g *mockdb) queryIndex(dataset string, q *Query) ([]string, error) {
+	q, err := q.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	index := func(name string) gdbSimpleIndex {
+		return g.index[dataset+"."+name]
+	}
+
+	var recordIDs []string
+	for _, recordID := range g.ids(dataset) {
+		if q.match(recordID, index) {
+			recordIDs = append(recordIDs, recordID)
+		}
+	}
+
+	return q.page(recordIDs, index), nil
+}
+
+func (g *mockdb) Count(dataset string, q *Query) (int, error) {
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(recordIDs), nil
+}
+
+func (g *mockdb) GroupBy(dataset string, index string, q *Query) (map[string]int, error) {
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupIndex(recordIDs, g.index[dataset+"."+index]), nil
+}
+
+func (g *mockdb) Sum(dataset string, index string, q *Query) (float64, error) {
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return sumIndex(recordIDs, g.index[dataset+"."+index]), nil
+}
+
+func (g *mockdb) Min(dataset string, index string, q *Query) (float64, error) {
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return minIndex(recordIDs, g.index[dataset+"."+index])
+}
+
+func (g *mockdb) Max(dataset string, index string, q *Query) (float64, error) {
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return 0, err
+	}
+
+	return maxIndex(recordIDs, g.index[dataset+"."+index])
+}
+
+//ids returns the sorted ids of all records in dataset
+func (g *mockdb) ids(dataset string) []string {
+	var ids []string
+	for id := range g.data {
+		if ds, _, _, err := ParseID(id); err == nil && ds == dataset {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
 func (g *mockdb) Delete(id string) error {
+	g.unindex(id)
 	delete(g.data, id)
+	delete(g.revisions, id)
 	return nil
 }
 
+func (g *mockdb) DeleteContext(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.Delete(id)
+}
+
 func (g *mockdb) DeleteOrFail(id string) error {
 	_, exists := g.data[id]
 	if !exists {
 		return fmt.Errorf("record %s does not exist", id)
 	}
 
+	g.unindex(id)
 	delete(g.data, id)
+	delete(g.revisions, id)
+	return nil
+}
+
+//unindex removes id from all indexes of its dataset
+func (g *mockdb) unindex(id string) {
+	dataset, _, _, err := ParseID(id)
+	if err != nil {
+		return
+	}
+
+	for key, index := range g.index {
+		if strings.HasPrefix(key, dataset+".") {
+			delete(index, id)
+		}
+	}
+
+	for key, index := range g.textIndex {
+		if strings.HasPrefix(key, dataset+".") {
+			index.remove(id)
+		}
+	}
+}
+
+func (g *mockdb) VerifyIndexes(dataset string) (*IndexReport, error) {
+	report := &IndexReport{Dataset: dataset}
+	stale := map[string]bool{}
+	for key, index := range g.index {
+		if strings.HasPrefix(key, dataset+".") {
+			for id := range index {
+				if _, ok := g.data[id]; !ok {
+					stale[id] = true
+				}
+			}
+		}
+	}
+	report.Stale = sortedKeys(stale)
+
+	return report, nil
+}
+
+func (g *mockdb) Reindex(dataset string) error {
+	for key := range g.index {
+		if strings.HasPrefix(key, dataset+".") {
+			delete(g.index, key)
+		}
+	}
+
+	for key := range g.textIndex {
+		if strings.HasPrefix(key, dataset+".") {
+			delete(g.textIndex, key)
+		}
+	}
+
+	for _, id := range g.ids(dataset) {
+		g.Insert(g.data[id])
+	}
+
+	return nil
+}
+
+//Compact validates policy only; mock records are kept by id and have no blocks to rewrite
+func (g *mockdb) Compact(dataset string, policy CompactPolicy) (map[string]string, error) {
+	if _, err := policy.internal(); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{}, nil
+}
+
+//RotateKey switches the mock to newKey; mock records are not encrypted
+func (g *mockdb) RotateKey(oldKey, newKey string) error {
+	if len(newKey) == 0 || oldKey == newKey {
+		return errors.New("new encryption key must be set and differ from the old one")
+	}
+
+	g.config.EncryptionKey = newKey
 	return nil
 }
 
@@ -186,7 +590,7 @@ func (g *mockdb) Lock(m Model) error {
 
 	for _, l := range m.(LockableModel).GetLockFileNames() {
 		key := m.GetSchema().dataset + "." + l
-		if _, ok := g.locks[l]; !ok {
+		if _, ok := g.locks[key]; !ok {
 			g.locks[key] = true
 		} else {
 			return errors.New("Lock file already exist: " + l)
@@ -217,8 +621,7 @@ func (g *mockdb) GetMails() []*mail {
 }
 
 func (g *mockdb) StartTransaction(name string) Transaction {
-	//todo return mock transaction
-	return &mocktransaction{name: name, db: g}
+	return &mocktransaction{name: name, db: g, buffer: newTxBuffer()}
 }
 
 func (g *mockdb) GetLastCommitTime() (time.Time, error) {
@@ -239,11 +642,15 @@ func (g *mockdb) Migrate(from Model, to Model) error {
 	}
 
 	for _, record := range records {
-		if err := record.Hydrate(to); err != nil {
+		m, err := newModel(to)
+		if err != nil {
+			return err
+		}
+		if err := record.Hydrate(m); err != nil {
 			return err
 		}
 
-		migrate = append(migrate, to)
+		migrate = append(migrate, m)
 	}
 
 	if err := g.InsertMany(migrate); err != nil {
@@ -268,6 +675,10 @@ func (g *mockdb) Sync() error {
 	return nil
 }
 
+func (g *mockdb) SyncContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
 func (g *mockdb) RegisterModel(dataset string, m Model) bool {
 	return true
 }
@@ -1,6 +1,7 @@
 package gitdb_test
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"testing"
@@ -57,6 +58,32 @@ func TestMockInsertMany(t *testing.T) {
 	}
 }
 
+func TestMockInsertUnique(t *testing.T) {
+	db := setupMock(t)
+
+	if err := db.Insert(&Account{AccountNo: 1, Email: "alice@example.com"}); err != nil {
+		t.Errorf("db.Insert failed: %s", err)
+	}
+
+	err := db.Insert(&Account{AccountNo: 2, Email: "alice@example.com"})
+	if !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("db.Insert want: %s, got: %v", gitdb.ErrUniqueViolation, err)
+	}
+}
+
+func TestMockTaggedIndexes(t *testing.T) {
+	db := setupMock(t)
+
+	if err := db.Insert(&Customer{CustomerNo: 1, Address: Address{City: "London"}}); err != nil {
+		t.Errorf("db.Insert failed: %s", err)
+	}
+
+	count, err := db.Count("Customer", gitdb.Where("Address.City", gitdb.SearchEquals, "London"))
+	if err != nil || count != 1 {
+		t.Errorf("db.Count want: 1, got: %d (%v)", count, err)
+	}
+}
+
 func TestMockGet(t *testing.T) {
 	db := setupMock(t)
 
@@ -77,6 +104,56 @@ func TestMockGet(t *testing.T) {
 	}
 }
 
+func TestMockUpdateIf(t *testing.T) {
+	db := setupMock(t)
+	m := getTestMessageWithId(101)
+
+	var got Message
+	revision, err := db.GetWithRevision(gitdb.ID(m), &got)
+	if err != nil || revision != 1 {
+		t.Fatalf("db.GetWithRevision returned revision %d and %v, want 1", revision, err)
+	}
+
+	if err := db.Insert(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.UpdateIf(&got, revision); !errors.Is(err, gitdb.ErrConflict) {
+		t.Errorf("db.UpdateIf with a stale revision returned %v, want ErrConflict", err)
+	}
+
+	if err := db.UpdateIf(&got, revision+1); err != nil {
+		t.Errorf("db.UpdateIf with the current revision failed: %s", err)
+	}
+}
+
+func TestMockContext(t *testing.T) {
+	db := setupMock(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := getTestMessage()
+	if err := db.InsertContext(ctx, m); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.InsertContext want: %s, got: %v", context.Canceled, err)
+	}
+	if err := db.GetContext(ctx, gitdb.ID(m), &Message{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.GetContext want: %s, got: %v", context.Canceled, err)
+	}
+	if err := db.SyncContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.SyncContext want: %s, got: %v", context.Canceled, err)
+	}
+	if err := db.UpdateIfContext(ctx, m, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.UpdateIfContext want: %s, got: %v", context.Canceled, err)
+	}
+	if _, err := db.GetWithRevisionContext(ctx, gitdb.ID(m), &Message{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("db.GetWithRevisionContext want: %s, got: %v", context.Canceled, err)
+	}
+
+	if err := db.InsertContext(context.Background(), m); err != nil {
+		t.Errorf("db.InsertContext failed: %s", err)
+	}
+}
+
 func TestMockExists(t *testing.T) {
 	db := setupMock(t)
 
@@ -121,6 +198,25 @@ func TestMockFetchBlock(t *testing.T) {
 	}
 }
 
+func TestMockIterate(t *testing.T) {
+	db := setupMock(t)
+
+	cursor, err := db.Iterate("Message")
+	if err != nil {
+		t.Errorf("db.Iterate failed: %s", err)
+		return
+	}
+
+	got := 0
+	for cursor.Next() {
+		got++
+	}
+
+	if want := 10; got != want {
+		t.Errorf("db.Iterate failed: want %d, got %d", want, got)
+	}
+}
+
 func TestMockSearch(t *testing.T) {
 	db := setupMock(t)
 
@@ -140,6 +236,66 @@ func TestMockSearch(t *testing.T) {
 	}
 }
 
+func TestMockSearchText(t *testing.T) {
+	db := setupMock(t)
+
+	m := getTestMessageWithId(200)
+	m.Body = "Guest requested a late checkout"
+	db.Insert(m)
+
+	records, err := db.SearchText("Message", "Body", "requesting")
+	if err != nil {
+		t.Errorf("db.SearchText failed: %s", err)
+	}
+
+	if len(records) != 1 || records[0].ID() != gitdb.ID(m) {
+		t.Errorf("db.SearchText want: [%s], got: %v", gitdb.ID(m), records)
+	}
+}
+
+func TestMockFind(t *testing.T) {
+	db := setupMock(t)
+
+	q := gitdb.And(
+		gitdb.Where("From", gitdb.SearchEquals, "alice@example.com"),
+		gitdb.Not(gitdb.Where("MessageId", gitdb.SearchLessThan, 108)),
+	)
+
+	results, err := db.Find("Message", q)
+	if err != nil {
+		t.Errorf("find failed with error - %s", err)
+	}
+
+	if want := 3; len(results) != want {
+		t.Errorf("find result count wrong. want: %d, got: %d", want, len(results))
+	}
+
+	results, err = db.Find("Message", gitdb.Where("MessageId", gitdb.SearchGlob, "10?"))
+	if err != nil {
+		t.Errorf("find failed with error - %s", err)
+	}
+
+	if want := 9; len(results) != want {
+		t.Errorf("find result count wrong. want: %d, got: %d", want, len(results))
+	}
+}
+
+func TestMockAggregate(t *testing.T) {
+	db := setupMock(t)
+
+	if count, err := db.Count("Message", nil); err != nil || count != 10 {
+		t.Errorf("db.Count want: 10, got: %d (%v)", count, err)
+	}
+
+	if sum, err := db.Sum("Message", "MessageId", nil); err != nil || sum != 1055 {
+		t.Errorf("db.Sum want: 1055, got: %v (%v)", sum, err)
+	}
+
+	if max, err := db.Max("Message", "MessageId", nil); err != nil || max != 110 {
+		t.Errorf("db.Max want: 110, got: %v (%v)", max, err)
+	}
+}
+
 func TestMockDelete(t *testing.T) {
 	db := setupMock(t)
 
@@ -154,6 +310,20 @@ func TestMockDelete(t *testing.T) {
 	if err := db.Delete(id); err != nil {
 		t.Errorf("db.Delete(%s) failed: %s", id, err)
 	}
+
+	count, err := db.Count("Message", gitdb.Where("MessageId", gitdb.SearchEquals, 110))
+	if err != nil || count != 0 {
+		t.Errorf("db.Count want: 0, got: %d (%v)", count, err)
+	}
+
+	report, err := db.VerifyIndexes("Message")
+	if err != nil || !report.OK() {
+		t.Errorf("db.VerifyIndexes want: OK, got: %+v (%v)", report, err)
+	}
+
+	if err := db.Reindex("Message"); err != nil {
+		t.Errorf("db.Reindex failed: %s", err)
+	}
 }
 func TestMockDeleteOrFail(t *testing.T) {
 	db := setupMock(t)
@@ -213,15 +383,65 @@ func TestMockStartTransaction(t *testing.T) {
 		t.Errorf("db.StartTransaction() returned: %v", nil)
 	}
 
-	tx.AddOperation(func() error { return nil })
-	tx.AddOperation(func() error { return nil })
-	tx.AddOperation(func() error { return errors.New("test error") })
-	tx.AddOperation(func() error { return nil })
+	tx.AddOperation(func(ctx context.Context) error { return nil })
+	tx.AddOperation(func(ctx context.Context) error { return nil })
+	tx.AddOperation(func(ctx context.Context) error { return errors.New("test error") })
+	tx.AddOperation(func(ctx context.Context) error { return nil })
 	if err := tx.Commit(); err == nil {
 		t.Error("transaction should fail on 3rd operation")
 	}
 }
 
+func TestMockTransactionOperations(t *testing.T) {
+	db := setupMock(t)
+	tx := db.StartTransaction("test")
+
+	err := tx.Update("Message/b0/101", func(m gitdb.Model) error {
+		m.(*Message).Body = "Updated"
+		return nil
+	})
+	if err != nil {
+		t.Errorf("tx.Update failed: %s", err)
+	}
+	if err := tx.Delete("Message/b0/102"); err != nil {
+		t.Errorf("tx.Delete failed: %s", err)
+	}
+	if err := tx.Lock(getTestMessageWithId(101)); err != nil {
+		t.Errorf("tx.Lock failed: %s", err)
+	}
+
+	if err := db.Get("Message/b0/102", &Message{}); err != nil {
+		t.Errorf("db.Get should not see pending delete: %s", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+
+	m := &Message{}
+	if err := db.Get("Message/b0/101", m); err != nil || m.Body != "Updated" {
+		t.Errorf("db.Get want updated record, got: %+v (%v)", m, err)
+	}
+	if err := db.Get("Message/b0/102", &Message{}); err == nil {
+		t.Errorf("db.Get should not find deleted record")
+	}
+
+	//unlocking then locking a locked record leaves it locked
+	tx = db.StartTransaction("relock")
+	if err := tx.Unlock(getTestMessageWithId(101)); err != nil {
+		t.Errorf("tx.Unlock failed: %s", err)
+	}
+	if err := tx.Lock(getTestMessageWithId(101)); err != nil {
+		t.Errorf("tx.Lock failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+	if err := db.Lock(getTestMessageWithId(101)); err == nil {
+		t.Errorf("db.Lock should fail if the record is still locked")
+	}
+}
+
 func TestMockGetLastCommitTime(t *testing.T) {
 	db := setupMock(t)
 	if _, err := db.GetLastCommitTime(); err != nil {
@@ -245,3 +465,16 @@ func TestMockConfig(t *testing.T) {
 		t.Errorf("db.Config != getMockConfig()")
 	}
 }
+
+func TestMockCompact(t *testing.T) {
+	db := setupMock(t)
+
+	if _, err := db.Compact("Message", gitdb.CompactPolicy{Method: "daily", Limit: 10}); err == nil {
+		t.Errorf("db.Compact should fail with an invalid method")
+	}
+
+	moved, err := db.Compact("Message", gitdb.CompactPolicy{Method: gitdb.BlockBySize, Limit: 1024})
+	if err != nil || len(moved) != 0 {
+		t.Errorf("db.Compact want: no moved records, got: %v (%v)", moved, err)
+	}
+}
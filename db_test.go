@@ -1,6 +1,7 @@
 package gitdb_test
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -11,16 +12,27 @@ func TestMigrate(t *testing.T) {
 	teardown := setup(t, nil)
 	defer teardown(t)
 
-	m := getTestMessageWithId(0)
-	if err := insert(m, false); err != nil {
-		t.Errorf("insert failed: %s", err)
+	for i := 0; i < 2; i++ {
+		m := getTestMessageWithId(i)
+		if err := insert(m, false); err != nil {
+			t.Errorf("insert failed: %s", err)
+		}
 	}
 
+	m := &Message{}
 	m2 := &MessageV2{}
 
 	if err := testDb.Migrate(m, m2); err != nil {
 		t.Errorf("testDb.Migrate() returned error - %s", err)
 	}
+
+	if count, err := testDb.Count("MessageV2", nil); err != nil || count != 2 {
+		t.Errorf("testDb.Count(MessageV2) want: 2, got: %d (%v)", count, err)
+	}
+
+	if count, err := testDb.Count("Message", nil); err != nil || count != 0 {
+		t.Errorf("testDb.Count(Message) want: 0, got: %d (%v)", count, err)
+	}
 }
 
 func TestNewConfig(t *testing.T) {
@@ -52,6 +64,16 @@ func TestConfigValidate(t *testing.T) {
 	if err := cfg.Validate(); err == nil {
 		t.Errorf("cfg.Validate should fail if DbPath is %s", cfg.DBPath)
 	}
+
+	cfg = &gitdb.Config{DBPath: dbPath, BlockFormat: "xml"}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("cfg.Validate should fail if BlockFormat is %s", cfg.BlockFormat)
+	}
+
+	cfg = &gitdb.Config{DBPath: dbPath, EncryptionKey: "key", KeyProvider: gitdb.NewEnvKeyProvider("GITDB")}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("cfg.Validate should fail if EncryptionKey and KeyProvider are both set")
+	}
 }
 
 func TestGetLastCommitTime(t *testing.T) {
@@ -63,3 +85,39 @@ func TestGetLastCommitTime(t *testing.T) {
 		t.Errorf("dbConn.GetLastCommitTime() returned error - %s", err)
 	}
 }
+
+//Memo implements Model with value receivers
+type Memo struct {
+	MessageId int
+	Body      string
+}
+
+func (m Memo) GetSchema() *gitdb.Schema {
+	indexes := map[string]interface{}{"Body": m.Body}
+	return gitdb.NewSchema("Memo", "b0", fmt.Sprintf("%d", m.MessageId), indexes)
+}
+
+func (m Memo) Validate() error     { return nil }
+func (m Memo) ShouldEncrypt() bool { return false }
+func (m Memo) BeforeInsert() error { return nil }
+
+func TestMigrateToValueModel(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Memo", Memo{})
+
+	for i := 0; i < 2; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	if err := testDb.Migrate(&Message{}, Memo{}); err != nil {
+		t.Fatalf("testDb.Migrate() returned error - %s", err)
+	}
+
+	records, err := testDb.Search("Memo", []*gitdb.SearchParam{{Index: "Body", Value: "Hello"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 2 {
+		t.Errorf("testDb.Search(Memo) want: 2 records, got: %d (%v)", len(records), err)
+	}
+}
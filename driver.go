@@ -1,14 +1,16 @@
 package gitdb
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type dbDriver interface {
 	name() string
 	setup(db *gitdb) error
-	sync() error
+	sync(ctx context.Context) error
 	commit(filePath string, msg string, user *User) error
-	undo() error
-	changedFiles() []string
+	changedFiles(ctx context.Context) []string
 	lastCommitTime() (time.Time, error)
 }
 
@@ -17,6 +19,6 @@ type gitDBDriver interface {
 	init() error
 	clone() error
 	addRemote() error
-	pull() error
-	push() error
+	pull(ctx context.Context) error
+	push(ctx context.Context) error
 }
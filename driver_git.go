@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -122,8 +123,8 @@ func (d *gitDriver) addRemote() error {
 	return nil
 }
 
-func (d *gitDriver) sync() error {
-	return d.driver.sync()
+func (d *gitDriver) sync(ctx context.Context) error {
+	return d.driver.sync(ctx)
 }
 
 func (d *gitDriver) commit(filePath string, msg string, user *User) error {
@@ -137,12 +138,8 @@ func (d *gitDriver) commit(filePath string, msg string, user *User) error {
 	return nil
 }
 
-func (d *gitDriver) undo() error {
-	return d.driver.undo()
-}
-
-func (d *gitDriver) changedFiles() []string {
-	return d.driver.changedFiles()
+func (d *gitDriver) changedFiles(ctx context.Context) []string {
+	return d.driver.changedFiles(ctx)
 }
 
 func (d *gitDriver) lastCommitTime() (time.Time, error) {
@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"os/exec"
 	"strings"
@@ -77,20 +78,32 @@ func (d *gitBinaryDriver) addRemote() error {
 	return nil
 }
 
-func (d *gitBinaryDriver) sync() error {
-	if err := d.pull(); err != nil {
+func (d *gitBinaryDriver) sync(ctx context.Context) error {
+	if err := d.pull(ctx); err != nil {
 		return err
 	}
-	if err := d.push(); err != nil {
+	if err := d.push(ctx); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (d *gitBinaryDriver) pull() error {
-	cmd := exec.Command("git", "-C", d.absDBPath, "pull", "online", "master")
+// pull fetches online/master, which is cancelled with ctx, and merges it. The merge is not
+// cancelled as a git killed part way through a merge leaves the repository locked
+func (d *gitBinaryDriver) pull(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", d.absDBPath, "fetch", "online", "master")
 	// log(utils.CmdToString(cmd))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Error(string(out))
+
+		return errors.New("failed to pull data from online remote")
+	}
+
+	cmd = exec.Command("git", "-C", d.absDBPath, "merge", "--no-edit", "FETCH_HEAD")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		log.Error(string(out))
 
@@ -100,10 +113,13 @@ func (d *gitBinaryDriver) pull() error {
 	return nil
 }
 
-func (d *gitBinaryDriver) push() error {
-	cmd := exec.Command("git", "-C", d.absDBPath, "push", "online", "master")
+func (d *gitBinaryDriver) push(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "git", "-C", d.absDBPath, "push", "online", "master")
 	// log(utils.CmdToString(cmd))
 	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		log.Error(string(out))
 		return errors.New("failed to push data to online remotes")
 	}
@@ -144,31 +160,12 @@ func (d *gitBinaryDriver) commit(filePath string, msg string, user *User) error
 	return nil
 }
 
-func (d *gitBinaryDriver) undo() error {
-	cmd := exec.Command("git", "-C", d.absDBPath, "checkout", ".")
-	// log(utils.CmdToString(cmd))
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Error(string(out))
-		return err
-	}
-
-	cmd = exec.Command("git", "-C", d.absDBPath, "clean", "-fd")
-	// log(utils.CmdToString(cmd))
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Error(string(out))
-		return err
-	}
-
-	log.Info("changes reverted")
-	return nil
-}
-
-func (d *gitBinaryDriver) changedFiles() []string {
+func (d *gitBinaryDriver) changedFiles(ctx context.Context) []string {
 	var files []string
 	if len(d.config.OnlineRemote) > 0 {
 		log.Test("getting list of changed files...")
 		// git fetch
-		cmd := exec.Command("git", "-C", d.absDBPath, "fetch", "online", "master")
+		cmd := exec.CommandContext(ctx, "git", "-C", d.absDBPath, "fetch", "online", "master")
 		if out, err := cmd.CombinedOutput(); err != nil {
 			log.Error(string(out))
 			return files
@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"os"
 	"time"
@@ -27,7 +28,7 @@ func (d *localDriver) setup(db *gitdb) error {
 	return nil
 }
 
-func (d *localDriver) sync() error {
+func (d *localDriver) sync(ctx context.Context) error {
 	return nil
 }
 
@@ -36,12 +37,7 @@ func (d *localDriver) commit(filePath string, msg string, user *User) error {
 	return nil
 }
 
-func (d *localDriver) undo() error {
-	log.Info("changes reverted")
-	return nil
-}
-
-func (d *localDriver) changedFiles() []string {
+func (d *localDriver) changedFiles(ctx context.Context) []string {
 	var files []string
 	return files
 }
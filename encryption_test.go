@@ -0,0 +1,501 @@
+package gitdb_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+)
+
+func TestEncryption(t *testing.T) {
+	cfg := getConfig()
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	m := getTestMessageWithId(0)
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	blockFile := filepath.Join(cfg.DBPath, "data", "Message", "b0.json")
+	data, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+	if !strings.Contains(string(data), `"Message/b0/0": "gcm1:`) {
+		t.Errorf("record should be AES-GCM encrypted: %s", data)
+	}
+
+	//records encrypted with AES-CFB before AES-GCM was introduced are still readable
+	legacy, _ := json.Marshal(getTestMessageWithId(1))
+	legacyBlock, _ := json.Marshal(map[string]string{"Message/b1/1": encryptCFB(t, cfg.EncryptionKey, string(legacy))})
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(blockFile), "b1.json"), legacyBlock, 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	result := &Message{}
+	if err := testDb.Get("Message/b1/1", result); err != nil || result.MessageId != 1 {
+		t.Errorf("testDb.Get legacy record failed: %v", err)
+	}
+
+	testDb.Close()
+	wrongKey := getConfig()
+	wrongKey.EncryptionKey = "00000000000000000000000000000000"
+	testDb = getDbConn(t, wrongKey)
+	testDb.RegisterModel("Message", &Message{})
+
+	for _, id := range []string{"Message/b0/0", "Message/b1/1"} {
+		if err := testDb.Get(id, &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+			t.Errorf("testDb.Get(%s) want: %s, got: %v", id, gitdb.ErrDecryptionFailed, err)
+		}
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	cfg := getConfig()
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	oldKey := cfg.EncryptionKey
+	newKey := "0123456789abcdef0123456789abcdef"
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//a block left behind by an interrupted rotation
+	rotated, _ := json.Marshal(getTestMessageWithId(3))
+	enc, err := crypto.Encrypt(newKey, "", string(rotated))
+	if err != nil {
+		t.Fatalf("crypto.Encrypt failed: %s", err)
+	}
+	partialBlock, _ := json.Marshal(map[string]string{"Message/b1/3": enc})
+	if err := ioutil.WriteFile(filepath.Join(cfg.DBPath, "data", "Message", "b1.json"), partialBlock, 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	if err := testDb.RotateKey("00000000000000000000000000000000", newKey); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.RotateKey with wrong old key want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+
+	//the failed rotation is undone
+	if err := testDb.Get("Message/b0/0", &Message{}); err != nil {
+		t.Errorf("testDb.Get after failed rotation failed: %s", err)
+	}
+
+	//a block that cannot be decrypted stops the rotation after b0 was rewritten
+	b0File := filepath.Join(cfg.DBPath, "data", "Message", "b0.json")
+	b0, _ := ioutil.ReadFile(b0File)
+	bad, err := crypto.Encrypt("ffffffffffffffffffffffffffffffff", "", string(rotated))
+	if err != nil {
+		t.Fatalf("crypto.Encrypt failed: %s", err)
+	}
+	badBlock, _ := json.Marshal(map[string]string{"Message/b2/4": bad})
+	badFile := filepath.Join(cfg.DBPath, "data", "Message", "b2.json")
+	if err := ioutil.WriteFile(badFile, badBlock, 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	if err := testDb.RotateKey(oldKey, newKey); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.RotateKey with a bad block want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+
+	//only the rewritten block is put back; the partly rotated block is kept
+	if data, _ := ioutil.ReadFile(b0File); !bytes.Equal(data, b0) {
+		t.Errorf("b0.json should be restored after a failed rotation")
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(cfg.DBPath, "data", "Message", "b1.json")); !bytes.Equal(data, partialBlock) {
+		t.Errorf("b1.json should be kept after a failed rotation")
+	}
+	if err := testDb.Get("Message/b0/0", &Message{}); err != nil {
+		t.Errorf("testDb.Get after failed rotation failed: %s", err)
+	}
+
+	if err := os.Remove(badFile); err != nil {
+		t.Fatalf("os.Remove failed: %s", err)
+	}
+
+	if err := testDb.RotateKey(oldKey, newKey); err != nil {
+		t.Fatalf("testDb.RotateKey failed: %s", err)
+	}
+
+	if testDb.Config().EncryptionKey != newKey {
+		t.Errorf("connection should use the new key")
+	}
+
+	for _, id := range []string{"Message/b0/0", "Message/b0/2", "Message/b1/3"} {
+		if err := testDb.Get(id, &Message{}); err != nil {
+			t.Errorf("testDb.Get(%s) failed: %s", id, err)
+		}
+	}
+
+	out, err := exec.Command("git", "-C", filepath.Join(cfg.DBPath, "data"), "status", "--porcelain").CombinedOutput()
+	if err != nil || len(out) > 0 {
+		t.Errorf("rotation should be committed, got: %s", out)
+	}
+
+	//nothing left to rotate
+	if err := testDb.RotateKey(oldKey, newKey); err != nil {
+		t.Errorf("testDb.RotateKey again failed: %s", err)
+	}
+}
+
+//encryptCFB encrypts message the way gitdb did before AES-GCM
+func encryptCFB(t *testing.T, key, message string) string {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatalf("aes.NewCipher failed: %s", err)
+	}
+
+	cipherText := make([]byte, aes.BlockSize+len(message))
+	iv := cipherText[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		t.Fatalf("rand failed: %s", err)
+	}
+
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(cipherText[aes.BlockSize:], []byte(message))
+	return base64.URLEncoding.EncodeToString(cipherText)
+}
+
+type Card struct {
+	Number string `gitdb:"encrypt"`
+	Expiry string
+}
+
+type Patient struct {
+	gitdb.TimeStampedModel
+	PatientNo int
+	Name      string
+	Email     string `gitdb:"index,unique,encrypt"`
+	Card      Card
+}
+
+func (p *Patient) GetSchema() *gitdb.Schema {
+	return gitdb.NewSchema("Patient", "b0", fmt.Sprintf("%d", p.PatientNo), nil)
+}
+
+func (p *Patient) Validate() error     { return nil }
+func (p *Patient) IsLockable() bool    { return false }
+func (p *Patient) ShouldEncrypt() bool { return false }
+func (p *Patient) GetLockFileNames() []string {
+	return []string{}
+}
+
+func TestFieldEncryption(t *testing.T) {
+	cfg := getConfig()
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Patient", &Patient{})
+
+	patients := []*Patient{
+		{PatientNo: 1, Name: "Alice", Email: "alice@example.com", Card: Card{Number: "4111111111111111", Expiry: "01/30"}},
+		{PatientNo: 2, Name: "Bob", Email: "bob@example.com", Card: Card{Number: "5500000000000004", Expiry: "02/30"}},
+		{PatientNo: 3, Name: "Carol", Email: "carol@example.com"},
+	}
+	for _, p := range patients {
+		if err := testDb.Insert(p); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	block, err := ioutil.ReadFile(filepath.Join(cfg.DBPath, "data", "Patient", "b0.json"))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	//every record keeps its fields encrypted, not just the last one written
+	for _, secret := range []string{"alice@example.com", "bob@example.com", "carol@example.com", "4111111111111111"} {
+		if strings.Contains(string(block), secret) {
+			t.Errorf("block contains plaintext %s: %s", secret, block)
+		}
+	}
+	for _, plain := range []string{"Alice", "Carol", "01/30"} {
+		if !strings.Contains(string(block), plain) {
+			t.Errorf("block should contain unencrypted field %s: %s", plain, block)
+		}
+	}
+
+	//flush indexes to disk
+	if err := testDb.Reindex("Patient"); err != nil {
+		t.Errorf("testDb.Reindex failed: %s", err)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(cfg.DBPath, ".gitdb", "index", "Patient", "Email.json"))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+	if strings.Contains(string(index), "@example.com") || !strings.Contains(string(index), "hmac:") {
+		t.Errorf("index should only contain blind indexes: %s", index)
+	}
+
+	p := &Patient{}
+	if err := testDb.Get("Patient/b0/1", p); err != nil || p.Email != "alice@example.com" || p.Card.Number != "4111111111111111" {
+		t.Errorf("testDb.Get want decrypted fields, got: %+v (%v)", p, err)
+	}
+
+	records, err := testDb.Find("Patient", gitdb.Where("Email", gitdb.SearchEquals, "BOB@example.com"))
+	if err != nil || len(records) != 1 || records[0].ID() != "Patient/b0/2" {
+		t.Errorf("testDb.Find want: [Patient/b0/2], got: %d records (%v)", len(records), err)
+	}
+
+	records, err = testDb.Find("Patient", gitdb.Not(gitdb.Where("Email", gitdb.SearchEquals, "bob@example.com")))
+	if err != nil || len(records) != 2 {
+		t.Errorf("testDb.Find want: 2 records, got: %d records (%v)", len(records), err)
+	}
+
+	if _, err := testDb.Find("Patient", gitdb.Where("Email", gitdb.SearchContains, "bob")); err == nil {
+		t.Errorf("testDb.Find should fail to search an encrypted index with SearchContains")
+	}
+
+	err = testDb.Insert(&Patient{PatientNo: 4, Email: "Alice@example.com"})
+	if !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("testDb.Insert want: %s, got: %v", gitdb.ErrUniqueViolation, err)
+	}
+
+	newKey := "0123456789abcdef0123456789abcdef"
+	if err := testDb.RotateKey(cfg.EncryptionKey, newKey); err != nil {
+		t.Fatalf("testDb.RotateKey failed: %s", err)
+	}
+
+	records, err = testDb.Find("Patient", gitdb.Where("Email", gitdb.SearchEquals, "carol@example.com"))
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Find after RotateKey want: 1 record, got: %d records (%v)", len(records), err)
+	}
+
+	if err := testDb.Get("Patient/b0/2", p); err != nil || p.Card.Number != "5500000000000004" {
+		t.Errorf("testDb.Get after RotateKey want decrypted fields, got: %+v (%v)", p, err)
+	}
+}
+
+//Secret has a field of its own called Encrypted
+type Secret struct {
+	gitdb.TimeStampedModel
+	SecretNo  int
+	Encrypted bool
+}
+
+func (s *Secret) GetSchema() *gitdb.Schema {
+	return gitdb.NewSchema("Secret", "b0", fmt.Sprintf("%d", s.SecretNo), nil)
+}
+
+func (s *Secret) Validate() error     { return nil }
+func (s *Secret) IsLockable() bool    { return false }
+func (s *Secret) ShouldEncrypt() bool { return false }
+func (s *Secret) GetLockFileNames() []string {
+	return []string{}
+}
+
+func TestModelEncryptedField(t *testing.T) {
+	cfg := getConfig()
+	cfg.EncryptionKey = ""
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Secret", &Secret{})
+
+	if err := testDb.Insert(&Secret{SecretNo: 1, Encrypted: true}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	s := &Secret{}
+	if err := testDb.Get("Secret/b0/1", s); err != nil || !s.Encrypted {
+		t.Errorf("testDb.Get want: Encrypted true, got: %+v (%v)", s, err)
+	}
+
+	if _, err := testDb.Fetch("Secret"); err != nil {
+		t.Errorf("testDb.Fetch failed: %s", err)
+	}
+}
+
+//rotatingKeys is a KeyProvider whose current key id can be changed
+type rotatingKeys struct {
+	current string
+	keys    map[string]string
+}
+
+func (k *rotatingKeys) CurrentKey(dataset string) (string, string, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *rotatingKeys) Key(dataset, id string) (string, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("no key %q", id)
+	}
+	return key, nil
+}
+
+func TestBlindIndexKeyProvider(t *testing.T) {
+	keys := &rotatingKeys{current: "k1", keys: map[string]string{
+		"k1": "0123456789abcdef0123456789abcdef",
+		"k2": "fedcba9876543210fedcba9876543210",
+	}}
+
+	cfg := getConfig()
+	cfg.EncryptionKey = ""
+	cfg.KeyProvider = keys
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Patient", &Patient{})
+
+	//blind indexes need a key that does not follow the current key
+	if err := testDb.Insert(&Patient{PatientNo: 1, Email: "alice@example.com"}); err == nil {
+		t.Errorf("testDb.Insert should fail without Config.IndexKey")
+	}
+	if err := testDb.Close(); err != nil {
+		t.Fatalf("testDb.Close failed: %s", err)
+	}
+
+	cfg.IndexKey = "patient-index-key"
+	var err error
+	if testDb, err = gitdb.Open(cfg); err != nil {
+		t.Fatalf("gitdb.Open failed: %s", err)
+	}
+	testDb.RegisterModel("Patient", &Patient{})
+
+	if err := testDb.Insert(&Patient{PatientNo: 1, Email: "alice@example.com"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	keys.current = "k2"
+	if err := testDb.Insert(&Patient{PatientNo: 2, Email: "bob@example.com"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		records, err := testDb.Find("Patient", gitdb.Where("Email", gitdb.SearchEquals, email))
+		if err != nil || len(records) != 1 {
+			t.Errorf("testDb.Find(%s) want: 1 record, got: %d (%v)", email, len(records), err)
+		}
+	}
+
+	err = testDb.Insert(&Patient{PatientNo: 3, Email: "alice@example.com"})
+	if !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("testDb.Insert want: %s, got: %v", gitdb.ErrUniqueViolation, err)
+	}
+}
+
+//Doctor indexes its encrypted Phone by hand
+type Doctor struct {
+	gitdb.TimeStampedModel
+	DoctorNo int
+	Name     string
+	Phone    string `gitdb:"encrypt"`
+}
+
+func (d *Doctor) GetSchema() *gitdb.Schema {
+	indexes := make(map[string]interface{})
+	indexes["Name"] = d.Name
+	indexes["Phone"] = d.Phone
+
+	return gitdb.NewSchema("Doctor", "b0", fmt.Sprintf("%d", d.DoctorNo), indexes).
+		FullText("Profile", d.Name+" "+d.Phone)
+}
+
+func (d *Doctor) Validate() error     { return nil }
+func (d *Doctor) IsLockable() bool    { return false }
+func (d *Doctor) ShouldEncrypt() bool { return false }
+func (d *Doctor) GetLockFileNames() []string {
+	return []string{}
+}
+
+func TestEncryptedFieldIndexedByHand(t *testing.T) {
+	cfg := getConfig()
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Doctor", &Doctor{})
+
+	if err := testDb.Insert(&Doctor{DoctorNo: 1, Name: "Grey", Phone: "555-0100"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//flush indexes to disk
+	if err := testDb.Reindex("Doctor"); err != nil {
+		t.Fatalf("testDb.Reindex failed: %s", err)
+	}
+
+	indexDir := filepath.Join(cfg.DBPath, ".gitdb", "index", "Doctor")
+	err := filepath.Walk(indexDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if strings.Contains(string(data), "555-0100") {
+			t.Errorf("%s contains plaintext of an encrypted field: %s", path, data)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("filepath.Walk failed: %s", err)
+	}
+
+	records, err := testDb.Find("Doctor", gitdb.Where("Name", gitdb.SearchEquals, "grey"))
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Find want: 1 record, got: %d (%v)", len(records), err)
+	}
+
+	records, err = testDb.Find("Doctor", gitdb.Where("Phone", gitdb.SearchEquals, "555-0100"))
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Find by the encrypted index want: 1 record, got: %d (%v)", len(records), err)
+	}
+}
+
+func TestEncryptedFieldIndexCollision(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Doctor", &Doctor{})
+
+	//a plain index is not blinded because its value equals the value of an encrypted field
+	if err := testDb.Insert(&Doctor{DoctorNo: 1, Name: "x", Phone: "x"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	records, err := testDb.Find("Doctor", gitdb.Where("Name", gitdb.SearchEquals, "x"))
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Find by Name want: 1 record, got: %d (%v)", len(records), err)
+	}
+
+	records, err = testDb.Find("Doctor", gitdb.Where("Phone", gitdb.SearchEquals, "x"))
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Find by Phone want: 1 record, got: %d (%v)", len(records), err)
+	}
+}
+
+func TestEncryptedFieldFullText(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Doctor", &Doctor{})
+
+	//Phone is a substring of Name but only Phone is left out of the full-text index
+	if err := testDb.Insert(&Doctor{DoctorNo: 1, Name: "Grey", Phone: "re"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+	if err := testDb.Insert(&Doctor{DoctorNo: 2, Name: "Shepherd", Phone: "555-0100"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	records, err := testDb.SearchText("Doctor", "Profile", "grey")
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.SearchText(grey) want: 1 record, got: %d (%v)", len(records), err)
+	}
+
+	records, err = testDb.SearchText("Doctor", "Profile", "555")
+	if err != nil || len(records) != 0 {
+		t.Errorf("testDb.SearchText(555) want: 0 records, got: %d (%v)", len(records), err)
+	}
+}
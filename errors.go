@@ -3,14 +3,19 @@ package gitdb
 import "github.com/gogitdb/gitdb/v2/internal/errors"
 
 var (
-	ErrNoRecords       = errors.ErrNoRecords
-	ErrRecordNotFound  = errors.ErrRecordNotFound
-	ErrInvalidRecordID = errors.ErrInvalidRecordID
-	ErrDBSyncFailed    = errors.ErrDBSyncFailed
-	ErrLowBattery      = errors.ErrLowBattery
-	ErrNoOnlineRemote  = errors.ErrNoOnlineRemote
-	ErrAccessDenied    = errors.ErrAccessDenied
-	ErrInvalidDataset  = errors.ErrInvalidDataset
+	ErrNoRecords        = errors.ErrNoRecords
+	ErrRecordNotFound   = errors.ErrRecordNotFound
+	ErrInvalidRecordID  = errors.ErrInvalidRecordID
+	ErrDBSyncFailed     = errors.ErrDBSyncFailed
+	ErrLowBattery       = errors.ErrLowBattery
+	ErrNoOnlineRemote   = errors.ErrNoOnlineRemote
+	ErrAccessDenied     = errors.ErrAccessDenied
+	ErrInvalidDataset   = errors.ErrInvalidDataset
+	ErrUniqueViolation  = errors.ErrUniqueViolation
+	ErrCorruptBlock     = errors.ErrCorruptBlock
+	ErrDecryptionFailed = errors.ErrDecryptionFailed
+	ErrConflict         = errors.ErrConflict
+	ErrCommitPending    = errors.ErrCommitPending
 )
 
 type ResolvableError interface {
@@ -48,7 +48,7 @@ func (b *BookingModel) GetSchema() *gitdb.Schema {
 	//Name of schema
 	name := "Booking"
 	//Block of schema
-	block := b.CreatedAt.Format("200601")
+	block := gitdb.AutoBlock("", b, gitdb.BlockByMonth, 0)
 	//Record of schema
 	record := string(b.Type) + "_" + b.CreatedAt.Format("20060102150405")
 
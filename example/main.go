@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -63,9 +64,20 @@ func testTransaction() {
 	t.Commit()
 }
 
-func updateRoom() error  { println("updating room..."); return nil }
-func lockRoom() error    { println("locking room"); return errors.New("cannot lock room") }
-func saveBooking() error { println("saving booking"); return nil }
+func updateRoom(ctx context.Context) error {
+	println("updating room...")
+	return nil
+}
+
+func lockRoom(ctx context.Context) error {
+	println("locking room")
+	return errors.New("cannot lock room")
+}
+
+func saveBooking(ctx context.Context) error {
+	println("saving booking")
+	return nil
+}
 
 func testWrite() {
 	ticker := time.NewTicker(time.Second * 4)
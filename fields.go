@@ -0,0 +1,172 @@
+package gitdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//encryptFields encrypts the fields of record data tagged `gitdb:"encrypt"` with the current key of dataset
+func (g *gitdb) encryptFields(dataset, data string, fields []taggedField) (string, error) {
+	paths := make([][]string, len(fields))
+	for i, f := range fields {
+		paths[i] = f.path
+	}
+
+	return db.EncryptFields(data, paths, func(value string) (string, error) {
+		return g.encrypt(dataset, value)
+	})
+}
+
+//blindIndex returns the blind index of value i.e. an HMAC of value keyed by the index key.
+//Values are compared case-insensitively like SearchEquals does.
+//Unset values are returned as is so they stay exempt from unique checks
+func (g *gitdb) blindIndex(dataset string, value interface{}) (interface{}, error) {
+	if isEmptyIndexValue(value) {
+		return value, nil
+	}
+
+	key, err := g.config.indexKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.BlindIndex(key, strings.ToLower(indexString(value))), nil
+}
+
+//blindQuery returns a copy of q with the values of conditions on blind indexes of dataset
+//replaced by their blind index. Blind indexes can only be matched for equality
+func (g *gitdb) blindQuery(dataset string, q *Query) (*Query, error) {
+	m := g.model(dataset)
+	if m == nil || q == nil {
+		return q, nil
+	}
+
+	blind := schemaOf(m).blind
+	if len(blind) == 0 {
+		return q, nil
+	}
+
+	if blind[q.sortBy] {
+		return nil, fmt.Errorf("%s.%s is encrypted and cannot be sorted by", dataset, q.sortBy)
+	}
+
+	return q.blinded(func(index string) bool { return blind[index] }, func(value interface{}) (interface{}, error) {
+		return g.blindIndex(dataset, value)
+	})
+}
+
+//encryptedIndexCache caches the names of the indexes Model types derive from encrypted fields
+var encryptedIndexCache sync.Map //reflect.Type => map[string]bool
+
+//encryptedIndexes returns the names of the indexes m declares in GetSchema that are derived from its
+//encrypted fields. They are the indexes that differ between two empty models whose encrypted fields
+//are unset in one and set in the other, so it is the field an index comes from that makes it blind
+func encryptedIndexes(m Model) map[string]bool {
+	u := unwrap(m)
+	fields := encryptedFields(u)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	t := reflect.TypeOf(u)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if names, ok := encryptedIndexCache.Load(t); ok {
+		return names.(map[string]bool)
+	}
+
+	unset := reflect.New(t)
+	set := reflect.New(t)
+	for _, f := range fields {
+		setField(set.Elem(), f.field, markValue, true)
+	}
+
+	before := unset.Interface().(Model).GetSchema().indexes
+	after := set.Interface().(Model).GetSchema().indexes
+	names := map[string]bool{}
+	for name, value := range after {
+		if v, ok := before[name]; !ok || !reflect.DeepEqual(v, value) {
+			names[name] = true
+		}
+	}
+
+	encryptedIndexCache.Store(t, names)
+	return names
+}
+
+//setField sets the field at index path field of the addressable struct v to value(type of the field).
+//Pointers on the path are copied so that the struct v was copied from is left alone. A nil pointer
+//is allocated if alloc is set and otherwise leaves the field unset
+func setField(v reflect.Value, field []int, value func(reflect.Type) reflect.Value, alloc bool) {
+	for _, i := range field {
+		for v.Kind() == reflect.Ptr {
+			c := reflect.New(v.Type().Elem())
+			if !v.IsNil() {
+				c.Elem().Set(v.Elem())
+			} else if !alloc {
+				return
+			}
+			v.Set(c)
+			v = c.Elem()
+		}
+		v = v.Field(i)
+	}
+
+	v.Set(value(v.Type()))
+}
+
+//markValue returns a value of type t that differs from the zero value of t
+func markValue(t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString("gitdb")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Ptr:
+		v.Set(reflect.New(t.Elem()))
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(t, 1, 1))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Unix(1, 0)))
+		}
+	}
+	return v
+}
+
+//withoutEncryptedFields returns a copy of m with its encrypted fields unset or m if it has none
+func withoutEncryptedFields(m Model) Model {
+	u := unwrap(m)
+	fields := encryptedFields(u)
+	if len(fields) == 0 {
+		return m
+	}
+
+	v := reflect.ValueOf(u)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	c := reflect.New(v.Type())
+	c.Elem().Set(v)
+	for _, f := range fields {
+		setField(c.Elem(), f.field, reflect.Zero, false)
+	}
+
+	return c.Interface().(Model)
+}
@@ -0,0 +1,170 @@
+package gitdb
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+	"github.com/gogitdb/gitdb/v2/internal/fts"
+)
+
+//gdbTextIndex is an inverted full-text index
+type gdbTextIndex struct {
+	Terms map[string]map[string]int `json:"t"` //term => (recordID => frequency)
+	Docs  map[string][]string       `json:"d"` //recordID => distinct terms
+}
+type gdbTextIndexCache map[string]*gdbTextIndex //indexFile => full-text index
+
+func newTextIndex() *gdbTextIndex {
+	return &gdbTextIndex{
+		Terms: make(map[string]map[string]int),
+		Docs:  make(map[string][]string),
+	}
+}
+
+//add indexes terms against recordID replacing any terms previously indexed for it
+func (t *gdbTextIndex) add(recordID string, terms []string) {
+	t.remove(recordID)
+	if len(terms) == 0 {
+		return
+	}
+
+	frequencies := map[string]int{}
+	for _, term := range terms {
+		frequencies[term]++
+	}
+
+	distinct := make([]string, 0, len(frequencies))
+	for term, frequency := range frequencies {
+		if _, ok := t.Terms[term]; !ok {
+			t.Terms[term] = make(map[string]int)
+		}
+		t.Terms[term][recordID] = frequency
+		distinct = append(distinct, term)
+	}
+
+	sort.Strings(distinct)
+	t.Docs[recordID] = distinct
+}
+
+//remove deletes all terms indexed for recordID
+func (t *gdbTextIndex) remove(recordID string) {
+	for _, term := range t.Docs[recordID] {
+		delete(t.Terms[term], recordID)
+		if len(t.Terms[term]) == 0 {
+			delete(t.Terms, term)
+		}
+	}
+	delete(t.Docs, recordID)
+}
+
+//search returns the ids of records matching any of terms ranked by tf-idf relevance
+func (t *gdbTextIndex) search(terms []string) []string {
+	scores := map[string]float64{}
+	docCount := float64(len(t.Docs))
+	seen := map[string]bool{}
+	for _, term := range terms {
+		postings := t.Terms[term]
+		if seen[term] || len(postings) == 0 {
+			continue
+		}
+		seen[term] = true
+
+		idf := math.Log(1 + docCount/float64(len(postings)))
+		for recordID, frequency := range postings {
+			scores[recordID] += (1 + math.Log(float64(frequency))) * idf
+		}
+	}
+
+	recordIDs := make([]string, 0, len(scores))
+	for recordID := range scores {
+		recordIDs = append(recordIDs, recordID)
+	}
+
+	sort.Slice(recordIDs, func(i, j int) bool {
+		a, b := recordIDs[i], recordIDs[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	})
+
+	return recordIDs
+}
+
+//SearchText returns records in dataset whose full-text index name matches
+//any of the words in text, most relevant first
+func (g *gitdb) SearchText(dataset, name, text string) ([]*db.Record, error) {
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	index := g.textIndex(dataset, name)
+
+	g.indexMu.Lock()
+	recordIDs := index.search(fts.Tokenize(text))
+	g.indexMu.Unlock()
+
+	return g.hydrateRecords(context.Background(), recordIDs)
+}
+
+//textIndex returns the named full-text index of a dataset building
+//the dataset's indexes if it has not been loaded yet
+func (g *gitdb) textIndex(dataset, name string) *gdbTextIndex {
+	indexFile := g.textIndexFile(dataset, name)
+
+	g.indexMu.Lock()
+	index, ok := g.textIndexCache[indexFile]
+	built := g.indexedSets[dataset]
+	g.indexMu.Unlock()
+
+	if !ok && !built {
+		g.buildIndexTargeted(dataset)
+
+		g.indexMu.Lock()
+		index, ok = g.textIndexCache[indexFile]
+		g.indexMu.Unlock()
+	}
+
+	if !ok {
+		return newTextIndex()
+	}
+
+	g.events <- newReadEvent("...", indexFile)
+	return index
+}
+
+//cachedTextIndex returns the full-text index in indexFile from cache
+//reading it from disk first if need be. g.indexMu must be held
+func (g *gitdb) cachedTextIndex(indexFile string) *gdbTextIndex {
+	if _, ok := g.textIndexCache[indexFile]; !ok {
+		g.textIndexCache[indexFile] = g.readTextIndex(indexFile)
+	}
+
+	return g.textIndexCache[indexFile]
+}
+
+func (g *gitdb) readTextIndex(indexFile string) *gdbTextIndex {
+	index := newTextIndex()
+	if _, err := os.Stat(indexFile); err == nil {
+		data, err := ioutil.ReadFile(indexFile)
+		if err == nil {
+			err = json.Unmarshal(data, index)
+		}
+
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}
+	return index
+}
+
+func (g *gitdb) textIndexFile(dataset, name string) string {
+	return filepath.Join(g.indexPath(dataset), "fts", name+".json")
+}
@@ -0,0 +1,100 @@
+package gitdb_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+func TestSearchText(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	bodies := []string{
+		"Booking the conference halls for meetings",
+		"Guest requested a late checkout",
+		"Meeting room booked twice",
+	}
+
+	for i, body := range bodies {
+		m := getTestMessageWithId(i)
+		m.Body = body
+		if err := testDb.Insert(m); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	cases := []struct {
+		text string
+		want []int
+	}{
+		{"meeting", []int{0, 2}},
+		{"booked halls", []int{0, 2}},
+		{"the LATE check-outs", []int{1}},
+		{"the", nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.text, func(t *testing.T) {
+			records, err := testDb.SearchText("Message", "Body", tc.text)
+			if err != nil {
+				t.Errorf("testDb.SearchText failed: %s", err)
+				return
+			}
+
+			if len(records) != len(tc.want) {
+				t.Errorf("want %d results, got %d", len(tc.want), len(records))
+				return
+			}
+
+			for i, record := range records {
+				m := &Message{}
+				if err := record.Hydrate(m); err != nil {
+					t.Errorf("record.Hydrate failed: %s", err)
+				}
+
+				if m.MessageId != tc.want[i] {
+					t.Errorf("result %d: want MessageId %d, got %d", i, tc.want[i], m.MessageId)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchTextMissingIndex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(&MessageV2{MessageId: 1, From: "alice@example.com"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//MessageV2 has no full-text index so the first search builds its indexes and finds nothing
+	if records, err := testDb.SearchText("MessageV2", "Body", "hello"); err != nil || len(records) != 0 {
+		t.Fatalf("testDb.SearchText want: 0 records, got: %d (%v)", len(records), err)
+	}
+
+	//a block written behind the connection's back is only indexed if the indexes are built again
+	blockFile := filepath.Join(dbPath, "data", "MessageV2", "209901.json")
+	block := db.NewBlock(blockFile, nil)
+	block.Add("MessageV2/209901/2", `{"MessageId":2,"From":"eve@example.com"}`)
+	data, err := block.Encode()
+	if err != nil {
+		t.Fatalf("block.Encode failed: %s", err)
+	}
+	if err := ioutil.WriteFile(blockFile, data, 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	if records, err := testDb.SearchText("MessageV2", "Body", "hello"); err != nil || len(records) != 0 {
+		t.Fatalf("testDb.SearchText want: 0 records, got: %d (%v)", len(records), err)
+	}
+
+	records, err := testDb.Search("MessageV2", []*gitdb.SearchParam{{Index: "From", Value: "eve@example.com"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 0 {
+		t.Errorf("a search for a missing full-text index should not rebuild the indexes, got: %d records (%v)", len(records), err)
+	}
+}
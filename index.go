@@ -1,17 +1,17 @@
 package gitdb
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2/internal/db"
+	"github.com/gogitdb/gitdb/v2/internal/fts"
 )
 
 type gdbIndex map[string]gdbIndexValue
@@ -21,10 +21,10 @@ type gdbSimpleIndexCache map[string]gdbSimpleIndex //index => (recordID => value
 type gdbIndexValue struct {
 	Offset int         `json:"o"`
 	Len    int         `json:"l"`
+	Sum    int         `json:"s,omitempty"` //checksum of the record at Offset
 	Value  interface{} `json:"v"`
 }
 
-//TODO handle deletes
 func (g *gitdb) updateIndexes(dataBlock *db.Block) {
 	g.indexMu.Lock()
 	defer g.indexMu.Unlock()
@@ -34,27 +34,62 @@ func (g *gitdb) updateIndexes(dataBlock *db.Block) {
 	indexPath := g.indexPath(dataset)
 
 	log.Info("updating in-memory index: " + dataset)
-	//get line position of each record in the block
-	//p := extractPositions(dataBlock)
-
-	model := g.registry[dataset]
-	if model == nil {
-		if g.config.Factory != nil {
-			model = g.config.Factory(dataset)
-		}
-	}
+	//record the position of each record in the block file
+	g.cachePositions(dataset, dataBlock)
 
+	model := g.model(dataset)
 	if model == nil {
 		log.Error(fmt.Sprintf("model not found in registry or factory: %s", dataset))
 		return
 	}
 
-	var indexes map[string]interface{}
+	//tagged indexes are read straight from record data so models
+	//only need hydrating when GetSchema declares indexes by hand
+	tagged := taggedIndexes(model)
+	schema := model.GetSchema()
+	hydrate := len(schema.indexes) > 0 || len(schema.fullText) > 0
+	if hydrate {
+		//records are hydrated into a new model as the registered one may be a value
+		model, _ = newModel(model)
+	}
+
 	for _, record := range dataBlock.Records() {
-		if err := record.Hydrate(model); err != nil {
-			log.Error(fmt.Sprintf("record.Hydrate failed: %s %s", record.ID(), err))
+		indexes := map[string]interface{}{}
+		var fullText map[string]string
+		if hydrate {
+			if err := record.Hydrate(model); err != nil {
+				log.Error(fmt.Sprintf("record.Hydrate failed: %s %s", record.ID(), err))
+			}
+			schema = model.GetSchema()
+			//full-text indexes leave out the text of encrypted fields
+			fullText = withoutEncryptedFields(model).GetSchema().fullText
+			blind := encryptedIndexes(model)
+			for name, value := range schema.indexes {
+				//an encrypted field declared as an index by hand is blinded too
+				if blind[name] {
+					var err error
+					if value, err = g.blindIndex(dataset, value); err != nil {
+						log.Error(fmt.Sprintf("blind index failed: %s %s", record.ID(), err))
+						continue
+					}
+				}
+				indexes[name] = value
+			}
+		}
+
+		for _, ti := range tagged {
+			value, err := record.Value(ti.path...)
+			if err != nil {
+				log.Error(fmt.Sprintf("record.Value failed: %s %s", record.ID(), err))
+			}
+			//encrypted fields are indexed by their blind index only
+			if ti.encrypt {
+				if value, err = g.blindIndex(dataset, value); err != nil {
+					log.Error(fmt.Sprintf("blind index failed: %s %s", record.ID(), err))
+				}
+			}
+			indexes[ti.name] = value
 		}
-		indexes = model.GetSchema().indexes
 
 		//append index for id
 		recordID := record.ID()
@@ -65,14 +100,210 @@ func (g *gitdb) updateIndexes(dataBlock *db.Block) {
 			if _, ok := g.indexCache[indexFile]; !ok {
 				g.indexCache[indexFile] = g.readIndex(indexFile)
 			}
-			//g.indexCache[indexFile][recordID] = gdbIndexValue{
-			//	Offset: p[recordID][0],
-			//	Len:    p[recordID][1],
-			//	Value:  value,
-			//}
 			g.indexCache[indexFile][recordID] = value
 		}
+
+		for name, text := range fullText {
+			g.cachedTextIndex(g.textIndexFile(dataset, name)).add(recordID, fts.Tokenize(text))
+		}
+	}
+}
+
+//index returns the named index of a dataset building the dataset's indexes if
+//it has not been loaded yet. An index no record has a value for is empty
+func (g *gitdb) index(dataset, name string) gdbSimpleIndex {
+	indexFile := filepath.Join(g.indexPath(dataset), name+".json")
+
+	g.indexMu.Lock()
+	index, ok := g.indexCache[indexFile]
+	built := g.indexedSets[dataset]
+	g.indexMu.Unlock()
+
+	if !ok && !built {
+		g.buildIndexTargeted(dataset)
+
+		g.indexMu.Lock()
+		index = g.indexCache[indexFile]
+		g.indexMu.Unlock()
+	}
+
+	g.events <- newReadEvent("...", indexFile)
+	return index
+}
+
+//removeFromIndexes deletes recordIDs from every index of dataset
+func (g *gitdb) removeFromIndexes(dataset string, recordIDs ...string) {
+	if len(recordIDs) == 0 {
+		return
+	}
+
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	log.Info(fmt.Sprintf("removing %d records from index: %s", len(recordIDs), dataset))
+	g.indexUpdated = true
+	for _, indexFile := range g.indexFiles(dataset) {
+		if _, ok := g.indexCache[indexFile]; !ok {
+			g.indexCache[indexFile] = g.readIndex(indexFile)
+		}
+		for _, recordID := range recordIDs {
+			delete(g.indexCache[indexFile], recordID)
+			delete(g.positionCache[indexFile], recordID)
+		}
+	}
+
+	for _, indexFile := range g.textIndexFiles(dataset) {
+		textIndex := g.cachedTextIndex(indexFile)
+		for _, recordID := range recordIDs {
+			textIndex.remove(recordID)
+		}
+	}
+}
+
+//IndexReport describes the drift between the block files and the indexes of a dataset
+type IndexReport struct {
+	Dataset string
+	//Missing are ids of records in block files that are not in the id index
+	Missing []string
+	//Stale are ids in one or more indexes that have no record in block files
+	Stale []string
+}
+
+//OK reports whether the indexes of the dataset are consistent with its block files
+func (r *IndexReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0
+}
+
+//VerifyIndexes compares the indexes of dataset against its block files
+func (g *gitdb) VerifyIndexes(dataset string) (*IndexReport, error) {
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	recordIDs := map[string]bool{}
+	ds := db.LoadDataset(filepath.Join(g.dbDir(), dataset), g.config.keys())
+	for _, block := range ds.Blocks() {
+		for _, recordID := range block.RecordIDs() {
+			recordIDs[recordID] = true
+		}
+	}
+
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	indexed := map[string]bool{}
+	stale := map[string]bool{}
+	for _, indexFile := range g.indexFiles(dataset) {
+		index, ok := g.indexCache[indexFile]
+		if !ok {
+			index = g.readIndex(indexFile)
+		}
+		for recordID := range index {
+			if !recordIDs[recordID] {
+				stale[recordID] = true
+			}
+		}
+		if filepath.Base(indexFile) == "id.json" {
+			for recordID := range index {
+				indexed[recordID] = true
+			}
+		}
+	}
+
+	for _, indexFile := range g.textIndexFiles(dataset) {
+		index, ok := g.textIndexCache[indexFile]
+		if !ok {
+			index = g.readTextIndex(indexFile)
+		}
+		for recordID := range index.Docs {
+			if !recordIDs[recordID] {
+				stale[recordID] = true
+			}
+		}
+	}
+
+	report := &IndexReport{Dataset: dataset, Stale: sortedKeys(stale)}
+	for _, recordID := range sortedKeys(recordIDs) {
+		if !indexed[recordID] {
+			report.Missing = append(report.Missing, recordID)
+		}
+	}
+
+	return report, nil
+}
+
+//Reindex discards all indexes of dataset and rebuilds them from its block files
+func (g *gitdb) Reindex(dataset string) error {
+	if !g.isRegistered(dataset) {
+		return ErrInvalidDataset
+	}
+
+	log.Info("rebuilding index: " + dataset)
+	g.indexMu.Lock()
+	for _, indexFile := range g.indexFiles(dataset) {
+		delete(g.indexCache, indexFile)
+		delete(g.positionCache, indexFile)
+	}
+	for _, indexFile := range g.textIndexFiles(dataset) {
+		delete(g.textIndexCache, indexFile)
+	}
+	err := os.RemoveAll(g.indexPath(dataset))
+	g.indexMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	g.buildIndexTargeted(dataset)
+	return g.flushIndex()
+}
+
+//indexFiles returns the paths of all simple indexes of dataset
+//whether cached or on disk. g.indexMu must be held
+func (g *gitdb) indexFiles(dataset string) []string {
+	indexPath := g.indexPath(dataset)
+	indexFiles := diskIndexFiles(indexPath)
+	for indexFile := range g.indexCache {
+		if filepath.Dir(indexFile) == indexPath {
+			indexFiles[indexFile] = true
+		}
+	}
+	return sortedKeys(indexFiles)
+}
+
+//textIndexFiles returns the paths of all full-text indexes of dataset
+//whether cached or on disk. g.indexMu must be held
+func (g *gitdb) textIndexFiles(dataset string) []string {
+	indexPath := filepath.Dir(g.textIndexFile(dataset, "id"))
+	indexFiles := diskIndexFiles(indexPath)
+	for indexFile := range g.textIndexCache {
+		if filepath.Dir(indexFile) == indexPath {
+			indexFiles[indexFile] = true
+		}
 	}
+	return sortedKeys(indexFiles)
+}
+
+//diskIndexFiles returns the set of index files in indexPath
+func diskIndexFiles(indexPath string) map[string]bool {
+	indexFiles := map[string]bool{}
+	files, _ := ioutil.ReadDir(indexPath)
+	for _, file := range files {
+		indexFile := filepath.Join(indexPath, file.Name())
+		if !file.IsDir() && filepath.Ext(indexFile) == ".json" {
+			indexFiles[indexFile] = true
+		}
+	}
+	return indexFiles
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (g *gitdb) flushIndex() error {
@@ -82,24 +313,16 @@ func (g *gitdb) flushIndex() error {
 	if g.indexUpdated {
 		log.Test("flushing index")
 		for indexFile, data := range g.indexCache {
-
-			indexPath := filepath.Dir(indexFile)
-			if _, err := os.Stat(indexPath); err != nil {
-				if err := os.MkdirAll(indexPath, 0755); err != nil {
-					log.Error("Failed to write to index: " + indexFile)
-					return err
-				}
+			if positions, ok := g.positionCache[indexFile]; ok {
+				data = withPositions(data, positions)
 			}
-
-			// indexBytes, err := json.MarshalIndent(data, "", "\t")
-			indexBytes, err := json.Marshal(data)
-			if err != nil {
-				log.Error("Failed to write to index [" + indexFile + "]: " + err.Error())
+			if err := writeIndexFile(indexFile, data); err != nil {
 				return err
 			}
+		}
 
-			if err := ioutil.WriteFile(indexFile, indexBytes, 0744); err != nil {
-				log.Error("Failed to write to index: " + indexFile)
+		for indexFile, data := range g.textIndexCache {
+			if err := writeIndexFile(indexFile, data); err != nil {
 				return err
 			}
 		}
@@ -109,39 +332,167 @@ func (g *gitdb) flushIndex() error {
 	return nil
 }
 
+//withPositions returns index with the value of each record that has
+//a known position replaced by a gdbIndexValue carrying its position
+func withPositions(index gdbSimpleIndex, positions gdbIndex) gdbSimpleIndex {
+	data := make(gdbSimpleIndex, len(index))
+	for recordID, value := range index {
+		if iv, ok := positions[recordID]; ok {
+			iv.Value = value
+			data[recordID] = iv
+			continue
+		}
+		data[recordID] = value
+	}
+
+	return data
+}
+
+func writeIndexFile(indexFile string, data interface{}) error {
+	indexPath := filepath.Dir(indexFile)
+	if _, err := os.Stat(indexPath); err != nil {
+		if err := os.MkdirAll(indexPath, 0755); err != nil {
+			log.Error("Failed to write to index: " + indexFile)
+			return err
+		}
+	}
+
+	// indexBytes, err := json.MarshalIndent(data, "", "\t")
+	indexBytes, err := json.Marshal(data)
+	if err != nil {
+		log.Error("Failed to write to index [" + indexFile + "]: " + err.Error())
+		return err
+	}
+
+	if err := db.WriteFileAtomic(indexFile, indexBytes, 0744); err != nil {
+		log.Error("Failed to write to index: " + indexFile)
+		return err
+	}
+
+	return nil
+}
+
+//readIndex reads an index file from disk. Entries of the id index that carry
+//the position of their record are cached in g.positionCache. g.indexMu must be held
 func (g *gitdb) readIndex(indexFile string) gdbSimpleIndex {
 	rMap := make(gdbSimpleIndex)
 	if _, err := os.Stat(indexFile); err == nil {
 		data, err := ioutil.ReadFile(indexFile)
 		if err == nil {
-			err = json.Unmarshal(data, &rMap)
+			//decode numbers as json.Number so index values keep their type
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+			err = dec.Decode(&rMap)
 		}
 
 		if err != nil {
 			log.Error(err.Error())
 		}
 	}
+
+	if filepath.Base(indexFile) == "id.json" {
+		positions, ok := g.positionCache[indexFile]
+		if !ok {
+			positions = gdbIndex{}
+			g.positionCache[indexFile] = positions
+		}
+
+		for recordID, value := range rMap {
+			v, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			iv := gdbIndexValue{Value: v["v"]}
+			offset, _ := indexNumber(v["o"])
+			length, _ := indexNumber(v["l"])
+			sum, _ := indexNumber(v["s"])
+			iv.Offset, iv.Len, iv.Sum = int(offset), int(length), int(sum)
+
+			rMap[recordID] = iv.Value
+			//positions cached since the index was written are newer
+			if _, ok := positions[recordID]; !ok {
+				positions[recordID] = iv
+			}
+		}
+	}
+
 	return rMap
 }
 
+//idIndexFile returns the path of the id index of dataset
+func (g *gitdb) idIndexFile(dataset string) string {
+	return filepath.Join(g.indexPath(dataset), "id.json")
+}
+
+//cachePositions records the position of every record of block in its block file.
+//g.indexMu must be held
+func (g *gitdb) cachePositions(dataset string, block *db.Block) {
+	indexFile := g.idIndexFile(dataset)
+	positions, ok := g.positionCache[indexFile]
+	if !ok {
+		positions = gdbIndex{}
+		g.positionCache[indexFile] = positions
+	}
+
+	blockPositions := block.Positions()
+	for _, recordID := range block.RecordIDs() {
+		if pos, ok := blockPositions[recordID]; ok {
+			positions[recordID] = gdbIndexValue{Offset: pos[0], Len: pos[1], Sum: pos[2], Value: recordID}
+		} else {
+			//compressed blocks have no positions
+			delete(positions, recordID)
+		}
+	}
+}
+
+//positions returns the position in their block file of those recordIDs
+//of dataset whose position is known
+func (g *gitdb) positions(dataset string, recordIDs []string) map[string][]int {
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+
+	indexFile := g.idIndexFile(dataset)
+	if _, ok := g.indexCache[indexFile]; !ok {
+		if _, err := os.Stat(indexFile); err != nil {
+			return nil
+		}
+		g.indexCache[indexFile] = g.readIndex(indexFile)
+	}
+
+	positions := map[string][]int{}
+	for _, recordID := range recordIDs {
+		//positions indexed before they had checksums are read from the whole block
+		if iv, ok := g.positionCache[indexFile][recordID]; ok && iv.Len > 0 && iv.Sum != 0 {
+			positions[recordID] = []int{iv.Offset, iv.Len, iv.Sum}
+		}
+	}
+
+	return positions
+}
+
 func (g *gitdb) buildIndexSmart(changedFiles []string) {
 	for _, blockFile := range changedFiles {
 		log.Info("Building index for block: " + blockFile)
-		block := db.LoadBlock(filepath.Join(g.dbDir(), blockFile), g.config.EncryptionKey)
+		block := db.LoadBlock(filepath.Join(g.dbDir(), blockFile), g.config.keys())
 		g.updateIndexes(block)
 	}
 	log.Info("Building index complete")
 }
 
 func (g *gitdb) buildIndexTargeted(target string) {
-	ds := db.LoadDataset(filepath.Join(g.dbDir(), target), g.config.EncryptionKey)
+	ds := db.LoadDataset(filepath.Join(g.dbDir(), target), g.config.keys())
 	for _, block := range ds.Blocks() {
 		g.updateIndexes(block)
 	}
+
+	g.indexMu.Lock()
+	g.indexedSets[target] = true
+	g.indexMu.Unlock()
 }
 
 func (g *gitdb) buildIndexFull() {
-	datasets := db.LoadDatasets(g.dbDir(), g.config.EncryptionKey)
+	datasets := db.LoadDatasets(g.dbDir(), g.config.keys())
 	for _, ds := range datasets {
 		g.buildIndexTargeted(ds.Name())
 	}
@@ -149,43 +500,3 @@ func (g *gitdb) buildIndexFull() {
 		log.Error("gitDB: flushIndex failed: " + err.Error())
 	}
 }
-
-//extractPositions returns the position of all records in a block
-//as they would appear in the physical block file
-//a block can contain records from multiple physical block files
-//especially when *gitdb.doFetch is called so proceed with caution
-func extractPositions(b *db.Block) map[string][]int {
-	var positions = map[string][]int{}
-
-	data, err := json.MarshalIndent(b, "", "\t")
-	if err != nil {
-		log.Error(err.Error())
-	}
-
-	offset := 3
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) != "{" && strings.TrimSpace(line) != "}" {
-			length := len(line)
-
-			record := strings.SplitN(line, ":", 2)
-			if len(record) != 2 {
-				continue
-			}
-
-			recordID := strings.TrimSpace(record[0])
-			recordID = recordID[1 : len(recordID)-1]
-			positions[recordID] = []int{offset, length}
-
-			//account for newline
-			offset += length + 1
-		}
-	}
-
-	if len(positions) < 1 {
-		panic("no positions extracted from: " + b.Path())
-	}
-
-	return positions
-}
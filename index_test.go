@@ -0,0 +1,237 @@
+package gitdb_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+func TestDeleteRemovesFromIndexes(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		m := getTestMessageWithId(i)
+		m.Body = "index maintenance"
+		if err := testDb.Insert(m); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+		ids = append(ids, gitdb.ID(m))
+	}
+
+	if err := testDb.Delete(ids[1]); err != nil {
+		t.Errorf("testDb.Delete failed: %s", err)
+	}
+
+	records, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "MessageId", Value: 1}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 0 {
+		t.Errorf("testDb.Search want: 0 records, got: %d (%v)", len(records), err)
+	}
+
+	count, err := testDb.Count("Message", nil)
+	if err != nil || count != 2 {
+		t.Errorf("testDb.Count want: 2, got: %d (%v)", count, err)
+	}
+
+	records, err = testDb.SearchText("Message", "Body", "maintenance")
+	if err != nil || len(records) != 2 {
+		t.Errorf("testDb.SearchText want: 2 records, got: %d (%v)", len(records), err)
+	}
+
+	report, err := testDb.VerifyIndexes("Message")
+	if err != nil || !report.OK() {
+		t.Errorf("testDb.VerifyIndexes want: OK, got: %+v (%v)", report, err)
+	}
+}
+
+func TestSearchMissingIndex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessageWithId(0)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//an index no record has a value for is empty every time it is searched
+	for i := 0; i < 2; i++ {
+		records, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "Missing", Value: "x"}}, gitdb.SearchEquals)
+		if err != nil || len(records) != 0 {
+			t.Errorf("testDb.Search want: 0 records, got: %d (%v)", len(records), err)
+		}
+	}
+}
+
+func TestVerifyIndexesAndReindex(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	m := getTestMessageWithId(0)
+	if err := testDb.Insert(m); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+
+	//remove the block file behind gitdb's back
+	dataset, block, _, _ := gitdb.ParseID(gitdb.ID(m))
+	blockFile := filepath.Join(testDb.Config().DBPath, "data", dataset, block+".json")
+	if err := os.Remove(blockFile); err != nil {
+		t.Fatalf("os.Remove failed: %s", err)
+	}
+
+	report, err := testDb.VerifyIndexes("Message")
+	if err != nil {
+		t.Fatalf("testDb.VerifyIndexes failed: %s", err)
+	}
+
+	if report.OK() || len(report.Stale) != 1 || report.Stale[0] != gitdb.ID(m) {
+		t.Errorf("testDb.VerifyIndexes want stale: [%s], got: %+v", gitdb.ID(m), report)
+	}
+
+	if err := testDb.Reindex("Message"); err != nil {
+		t.Errorf("testDb.Reindex failed: %s", err)
+	}
+
+	report, err = testDb.VerifyIndexes("Message")
+	if err != nil || !report.OK() {
+		t.Errorf("testDb.VerifyIndexes want: OK, got: %+v (%v)", report, err)
+	}
+
+	if _, err := testDb.VerifyIndexes("NonExistent"); err != gitdb.ErrInvalidDataset {
+		t.Errorf("testDb.VerifyIndexes want: %s, got: %v", gitdb.ErrInvalidDataset, err)
+	}
+}
+
+func TestRecordPositions(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 0; i < 3; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//moves the records after Message/b0/0 in the block file
+	if err := testDb.Delete("Message/b0/0"); err != nil {
+		t.Errorf("testDb.Delete failed: %s", err)
+	}
+
+	//flush indexes to disk
+	if err := testDb.Reindex("Message"); err != nil {
+		t.Errorf("testDb.Reindex failed: %s", err)
+	}
+
+	dbPath := testDb.Config().DBPath
+	blockFile := filepath.Join(dbPath, "data", "Message", "b0.json")
+	block, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dbPath, ".gitdb", "index", "Message", "id.json"))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	positions := map[string]struct {
+		Offset int `json:"o"`
+		Len    int `json:"l"`
+	}{}
+	if err := json.Unmarshal(data, &positions); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s", err)
+	}
+
+	for _, id := range []string{"Message/b0/1", "Message/b0/2"} {
+		pos := positions[id]
+		if pos.Len == 0 || !strings.HasPrefix(string(block[pos.Offset:pos.Offset+pos.Len]), `"`+id+`"`) {
+			t.Errorf("position of %s does not point to its record: %+v", id, pos)
+		}
+
+		m := &Message{}
+		if err := testDb.Get(id, m); err != nil || gitdb.ID(m) != id {
+			t.Errorf("testDb.Get(%s) failed: %v", id, err)
+		}
+	}
+
+	//shift every record so that all positions are stale
+	if err := ioutil.WriteFile(blockFile, append([]byte("  "), block...), 0744); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	records, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "MessageId", Value: 2}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 1 || records[0].ID() != "Message/b0/2" {
+		t.Errorf("testDb.Search want: [Message/b0/2], got: %d records (%v)", len(records), err)
+	}
+}
+
+type Address struct {
+	Street string
+	City   string `gitdb:"index"`
+}
+
+type Customer struct {
+	gitdb.TimeStampedModel
+	CustomerNo int    `gitdb:"index"`
+	Email      string `json:"email" gitdb:"index,unique"`
+	Address    Address
+}
+
+func (c *Customer) GetSchema() *gitdb.Schema {
+	return gitdb.NewSchema("Customer", "b0", fmt.Sprintf("%d", c.CustomerNo), nil)
+}
+
+func (c *Customer) Validate() error     { return nil }
+func (c *Customer) IsLockable() bool    { return false }
+func (c *Customer) ShouldEncrypt() bool { return false }
+func (c *Customer) GetLockFileNames() []string {
+	return []string{}
+}
+
+func TestTaggedIndexes(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Customer", &Customer{})
+
+	customers := []*Customer{
+		{CustomerNo: 1, Email: "alice@example.com", Address: Address{City: "London"}},
+		{CustomerNo: 2, Email: "bob@example.com", Address: Address{City: "Lagos"}},
+		{CustomerNo: 3, Email: "carol@example.com", Address: Address{City: "London"}},
+	}
+
+	for _, c := range customers {
+		if err := testDb.Insert(c); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	indexes := gitdb.Indexes(customers[0])
+	if indexes["Address.City"] != "London" || indexes["Email"] != "alice@example.com" || indexes["CustomerNo"] != 1 {
+		t.Errorf("gitdb.Indexes want tagged values, got: %v", indexes)
+	}
+
+	err := testDb.Insert(&Customer{CustomerNo: 4, Email: "alice@example.com"})
+	if !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("testDb.Insert want: %s, got: %v", gitdb.ErrUniqueViolation, err)
+	}
+
+	//rebuild indexes from record data
+	if err := testDb.Reindex("Customer"); err != nil {
+		t.Errorf("testDb.Reindex failed: %s", err)
+	}
+
+	q := gitdb.And(
+		gitdb.Where("Address.City", gitdb.SearchEquals, "london"),
+		gitdb.Where("CustomerNo", gitdb.SearchGreaterThan, 1),
+	)
+	records, err := testDb.Find("Customer", q)
+	if err != nil || len(records) != 1 || records[0].ID() != "Customer/b0/3" {
+		t.Errorf("testDb.Find want: [Customer/b0/3], got: %d records (%v)", len(records), err)
+	}
+}
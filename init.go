@@ -11,6 +11,7 @@ import (
 )
 
 var mu sync.Mutex
+var connsMu sync.Mutex //guards conns
 var conns map[string]GitDb
 
 // Open opens a connection to GitDB
@@ -21,14 +22,10 @@ func Open(config *Config) (GitDb, error) {
 		return nil, err
 	}
 
-	if conns == nil {
-		conns = make(map[string]GitDb)
-	}
-
 	if cfg.Mock {
 		conn := newMockConnection()
 		conn.configure(cfg)
-		conns[cfg.ConnectionName] = conn
+		addConn(cfg.ConnectionName, conn)
 		return conn, nil
 	}
 
@@ -72,13 +69,27 @@ func Open(config *Config) (GitDb, error) {
 		conn.loopStarted = true
 	}
 
-	conns[cfg.ConnectionName] = conn
+	addConn(cfg.ConnectionName, conn)
 	return conn, nil
 }
 
+//addConn caches conn by name
+func addConn(name string, conn GitDb) {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+
+	if conns == nil {
+		conns = make(map[string]GitDb)
+	}
+	conns[name] = conn
+}
+
 // Conn returns the last connection started by Open(*Config)
 // if you opened more than one connection use GetConn(name) instead
 func Conn() GitDb {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+
 	if len(conns) > 1 {
 		panic("Multiple gitdb connections found. Use GetConn function instead")
 	}
@@ -102,6 +113,9 @@ func Conn() GitDb {
 
 // GetConn returns a specific gitdb connection by name
 func GetConn(name string) GitDb {
+	connsMu.Lock()
+	defer connsMu.Unlock()
+
 	if _, ok := conns[name]; !ok {
 		panic("No gitdb connection found")
 	}
@@ -3,52 +3,190 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/errors"
 )
 
-//Encrypt message with key
-func Encrypt(key string, message string) string {
-	plainText := []byte(message)
+//envelopeV1 prefixes messages encrypted with AES-GCM without a key id and
+//envelopeV2 those encrypted with AES-GCM under a key id i.e gcm2:<key id>:<ciphertext>.
+//Messages without a known prefix are read as legacy AES-CFB which has no key id
+const (
+	envelopeV1 = "gcm1:"
+	envelopeV2 = "gcm2:"
+)
 
-	block, err := aes.NewCipher([]byte(key))
+//Keyring looks up the key with id that encrypts records of dataset
+type Keyring interface {
+	Key(dataset, id string) (string, error)
+}
+
+//Keychain is a Keyring that also knows the key new records of each dataset are encrypted with
+type Keychain interface {
+	Keyring
+	CurrentKey(dataset string) (id string, key string, err error)
+}
+
+//StaticKey is a Keychain with one key for every dataset and key id
+type StaticKey string
+
+//Key implements Keyring
+func (k StaticKey) Key(dataset, id string) (string, error) {
+	return string(k), nil
+}
+
+//CurrentKey implements Keychain. Static keys have no key id
+func (k StaticKey) CurrentKey(dataset string) (string, string, error) {
+	return "", string(k), nil
+}
+
+//ValidKeyID reports whether id can be stored in an envelope
+func ValidKeyID(id string) bool {
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+//Encrypt message with key using AES-GCM. The nonce is put at the beginning of the
+//ciphertext which is base64 encoded and enveloped with keyID if it is set
+func Encrypt(key, keyID, message string) (string, error) {
+	if !ValidKeyID(keyID) {
+		return "", fmt.Errorf("invalid key id: %s", keyID)
+	}
+
+	gcm, err := newGCM(key)
 	if err != nil {
-		println(err.Error())
-		return ""
+		return "", err
 	}
 
-	//IV needs to be unique, but doesn't have to be secure.
-	//It's common to put it at the beginning of the ciphertext.
-	cipherText := make([]byte, aes.BlockSize+len(plainText))
-	iv := cipherText[:aes.BlockSize]
-	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
-		println(err.Error())
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	cipherText := base64.URLEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(message), nil))
+	if len(keyID) == 0 {
+		return envelopeV1 + cipherText, nil
+	}
+
+	return envelopeV2 + keyID + ":" + cipherText, nil
+}
+
+//KeyID returns the id of the key secureMessage was encrypted with.
+//Messages encrypted without a key id return ""
+func KeyID(secureMessage string) string {
+	if !strings.HasPrefix(secureMessage, envelopeV2) {
 		return ""
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(cipherText[aes.BlockSize:], plainText)
+	parts := strings.SplitN(strings.TrimPrefix(secureMessage, envelopeV2), ":", 2)
+	return parts[0]
+}
+
+//DecryptWith decrypts secureMessage of dataset with the key from keys it was encrypted with
+func DecryptWith(keys Keyring, dataset, secureMessage string) (string, error) {
+	keyID := KeyID(secureMessage)
+	key, err := keys.Key(dataset, keyID)
+	if err != nil {
+		return "", decryptionFailed(fmt.Errorf("key %q of %s not found: %s", keyID, dataset, err))
+	}
+
+	return Decrypt(key, secureMessage)
+}
+
+//Decrypt message with key returning an error wrapping ErrDecryptionFailed
+//if the key is wrong or the message has been tampered with
+func Decrypt(key string, secureMessage string) (string, error) {
+	var encoded string
+	switch {
+	case strings.HasPrefix(secureMessage, envelopeV1):
+		encoded = strings.TrimPrefix(secureMessage, envelopeV1)
+	case strings.HasPrefix(secureMessage, envelopeV2):
+		parts := strings.SplitN(strings.TrimPrefix(secureMessage, envelopeV2), ":", 2)
+		if len(parts) != 2 {
+			return "", decryptionFailed(fmt.Errorf("invalid envelope"))
+		}
+		encoded = parts[1]
+	default:
+		return decryptCFB(key, secureMessage)
+	}
+
+	cipherText, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", decryptionFailed(err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", decryptionFailed(err)
+	}
+
+	if len(cipherText) < gcm.NonceSize() {
+		return "", decryptionFailed(fmt.Errorf("ciphertext too short"))
+	}
+
+	nonce := cipherText[:gcm.NonceSize()]
+	plainText, err := gcm.Open(nil, nonce, cipherText[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", decryptionFailed(err)
+	}
+
+	return string(plainText), nil
+}
 
-	//returns to base64 encoded string
-	encmess := base64.URLEncoding.EncodeToString(cipherText)
-	return encmess
+//IsEncrypted reports whether message looks like the output of Encrypt or its
+//AES-CFB predecessor. Records are stored as JSON objects when not encrypted
+func IsEncrypted(message string) bool {
+	return len(message) > 0 && !strings.HasPrefix(strings.TrimSpace(message), "{")
 }
 
-//Decrypt message with key
-func Decrypt(key string, secureMessage string) string {
+//BlindIndex returns a keyed HMAC-SHA256 of value so that equal values can be matched
+//without storing them. The HMAC key is derived from key so that the blind index of
+//a value cannot be used to check guesses against records encrypted with key
+func BlindIndex(key, value string) string {
+	derive := hmac.New(sha256.New, []byte(key))
+	derive.Write([]byte("gitdb blind index"))
+
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write([]byte(value))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+//decryptCFB decrypts messages written before AES-GCM was introduced.
+//CFB is unauthenticated so a wrong key is detected by the result not being JSON
+func decryptCFB(key string, secureMessage string) (string, error) {
 	cipherText, err := base64.URLEncoding.DecodeString(secureMessage)
 	if err != nil {
-		return ""
+		return "", decryptionFailed(err)
 	}
 
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
-		return ""
+		return "", decryptionFailed(err)
 	}
 
 	if len(cipherText) < aes.BlockSize {
-		return ""
+		return "", decryptionFailed(fmt.Errorf("ciphertext too short"))
 	}
 
 	iv := cipherText[:aes.BlockSize]
@@ -58,6 +196,13 @@ func Decrypt(key string, secureMessage string) string {
 	// XORKeyStream can work in-place if the two arguments are the same.
 	stream.XORKeyStream(cipherText, cipherText)
 
-	decodedmess := string(cipherText)
-	return decodedmess
+	if !json.Valid(cipherText) {
+		return "", decryptionFailed(fmt.Errorf("legacy record did not decrypt to json"))
+	}
+
+	return string(cipherText), nil
+}
+
+func decryptionFailed(err error) error {
+	return fmt.Errorf("%w: %s", errors.ErrDecryptionFailed, err)
 }
@@ -3,24 +3,45 @@ package db
 import (
 	"bytes"
 	"encoding/json"
-	"github.com/gogitdb/gitdb/v2/internal/errors"
+	goerrors "errors"
+	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/errors"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/digital"
 )
 
+//checksumSuffix is appended to a record id to make the key under which a FormatJSON block
+//file stores the checksum of the record. Record ids have three parts so it is never a record id.
+//Each record has its own checksum so that git can merge edits to different records
+const checksumSuffix = "/_checksum"
+
+//isChecksumKey reports whether key of a FormatJSON block file holds the checksum of a record
+func isChecksumKey(key string) bool {
+	return strings.Count(key, "/") == 3 && strings.HasSuffix(key, checksumSuffix)
+}
+
+var errChecksumMismatch = goerrors.New("checksum mismatch")
+
 //Block represents a block file
 type Block struct {
 	dataset    *Dataset
 	path       string
-	key        string
+	keys       crypto.Keyring
 	size       int64
 	badRecords []string
 	records    map[string]*Record
+	positions  map[string][]int
+	format     Format
+	compressed bool
 }
 
 //EmptyBlock is used for hydration
@@ -29,41 +50,82 @@ type EmptyBlock struct {
 }
 
 //HydrateByPositions should be called on EmptyBlock
-//pos must be []int{offset, position}
-func (b *EmptyBlock) HydrateByPositions(blockFilePath string, positions ...[]int) error {
+//positions maps record ids to []int{offset, length, checksum} of their line in blockFilePath
+//as recorded by Block.Positions. An error is returned if any position no longer holds
+//the expected record or fails its checksum so callers can fall back to Hydrate
+func (b *EmptyBlock) HydrateByPositions(blockFilePath string, positions map[string][]int) error {
 	fd, err := os.Open(blockFilePath)
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
 
-	blockJSON := []byte("{")
-	for i, pos := range positions {
+	//compressed blocks have no record positions
+	header := make([]byte, len(gzipHeader))
+	if _, err := fd.ReadAt(header, 0); err == nil && isCompressed(header) {
+		return fmt.Errorf("%s is compressed", blockFilePath)
+	}
 
-		fd.Seek(int64(pos[0]), 0)
-		line := make([]byte, pos[1])
-		fd.Read(line)
+	//only add records once every position has been verified
+	records := make(map[string]*Record, len(positions))
+	for recordID, pos := range positions {
+		if len(pos) != 3 || pos[0] < 0 || pos[1] <= 0 {
+			return fmt.Errorf("invalid position for %s: %v", recordID, pos)
+		}
 
-		line = bytes.TrimSpace(line)
-		ln := len(line) - 1
-		//are we at the end of seek
-		if i < len(positions)-1 {
-			//ensure line ends with a comma
-			if line[ln] != ',' {
-				line = append(line, ',')
+		//read one extra byte to make sure the line ends where we expect it to
+		line := make([]byte, pos[1]+1)
+		if _, err := fd.ReadAt(line, int64(pos[0])); err != nil {
+			return err
+		}
 
-			}
-		} else {
-			//make sure last line has no comma
-			if line[ln] == ',' {
-				line = line[0:ln]
-			}
+		if end := line[pos[1]]; end != ',' && end != '\n' {
+			return fmt.Errorf("stale position for %s", recordID)
+		}
+
+		if positionSum(line[:pos[1]]) != pos[2] {
+			return fmt.Errorf("stale position for %s: %s", recordID, errChecksumMismatch)
+		}
+
+		data, err := decodePosition(line[:pos[1]], recordID)
+		if err != nil {
+			return fmt.Errorf("stale position for %s: %s", recordID, err)
+		}
+
+		records[recordID] = newRecord(recordID, data)
+	}
+
+	for recordID, record := range records {
+		b.records[recordID] = record
+	}
+
+	return nil
+}
+
+//decodePosition returns the data of recordID from a line of a block file of either Format
+func decodePosition(line []byte, recordID string) (string, error) {
+	if line[0] == '{' {
+		id, data, err := decodeLine(line)
+		if err != nil {
+			return "", err
 		}
-		line = append(line, "\n"...)
-		blockJSON = append(blockJSON, line...)
+		if id != recordID {
+			return "", fmt.Errorf("found %s", id)
+		}
+		return data, nil
+	}
+
+	raw := map[string]string{}
+	if err := json.Unmarshal(append(append([]byte("{"), line...), '}'), &raw); err != nil {
+		return "", err
 	}
-	blockJSON = append(blockJSON, '}')
-	return json.Unmarshal(blockJSON, b)
+
+	data, ok := raw[recordID]
+	if !ok || len(raw) != 1 {
+		return "", fmt.Errorf("%s not found", recordID)
+	}
+
+	return data, nil
 }
 
 //Hydrate should be called on EmptyBlock
@@ -73,11 +135,20 @@ func (b *EmptyBlock) Hydrate(blockFilePath string) error {
 		return err
 	}
 
-	if err := json.Unmarshal(data, b); err != nil {
-		return err //errBadBlock
+	if data, err = uncompress(data); err != nil {
+		return corruptBlock(blockFilePath, err)
 	}
 
-	return err
+	if err := b.decode(data); err != nil {
+		return corruptBlock(blockFilePath, err)
+	}
+
+	return nil
+}
+
+//corruptBlock wraps an error found decoding blockFilePath in ErrCorruptBlock
+func corruptBlock(blockFilePath string, err error) error {
+	return fmt.Errorf("%w: %s: %s", errors.ErrCorruptBlock, blockFilePath, err)
 }
 
 //Dataset returns the dataset *Block belongs to
@@ -106,31 +177,144 @@ func (b *Block) Path() string {
 }
 
 //NewEmptyBlock should be used to store records from multiple blocks
-func NewEmptyBlock(key string) *EmptyBlock {
+func NewEmptyBlock(keys crypto.Keyring) *EmptyBlock {
 	return &EmptyBlock{Block{
-		key:        key,
+		keys:       keys,
 		records:    map[string]*Record{},
 		badRecords: []string{},
 	}}
 }
 
 //LoadBlock loads a block at a particular path
-func LoadBlock(blockFilePath, key string) *Block {
+//logging unreadable and corrupt blocks as bad blocks
+func LoadBlock(blockFilePath string, keys crypto.Keyring) *Block {
+	block, err := OpenBlock(blockFilePath, keys)
+	if err != nil {
+		log.Error(err.Error())
+		block.dataset.badBlocks = append(block.dataset.badBlocks, blockFilePath)
+	}
+
+	return block
+}
+
+//OpenBlock loads a block at a particular path returning an error
+//wrapping ErrCorruptBlock if the block fails its checksum or is truncated
+func OpenBlock(blockFilePath string, keys crypto.Keyring) (*Block, error) {
 	block := &Block{
 		path:       blockFilePath,
-		key:        key,
+		keys:       keys,
 		records:    map[string]*Record{},
 		badRecords: []string{},
 		//TODO figure out a neat way to inject key
-		dataset: &Dataset{path: filepath.Dir(blockFilePath), key: key},
+		dataset: &Dataset{path: filepath.Dir(blockFilePath), keys: keys},
 	}
 
-	if err := block.load(); err != nil {
-		log.Error(err.Error())
-		block.dataset.badBlocks = append(block.dataset.badBlocks, blockFilePath)
+	return block, block.load()
+}
+
+//Format returns the Format the block was read in or will be written in
+func (b *Block) Format() Format {
+	if len(b.format) == 0 {
+		return FormatJSON
 	}
+	return b.format
+}
 
-	return block
+//SetFormat sets the Format the block is written in by Encode
+func (b *Block) SetFormat(format Format) {
+	b.format = format
+}
+
+//Encode returns the block in its Format with one record per line
+//and records the position of each record in it. Compressed blocks are
+//gzipped and have no record positions
+func (b *Block) Encode() ([]byte, error) {
+	var data []byte
+	if b.Format() == FormatNDJSON {
+		data = b.encodeNDJSON()
+	} else {
+		var err error
+		if data, err = json.MarshalIndent(b, "", "\t"); err != nil {
+			return nil, err
+		}
+	}
+
+	b.size = int64(len(data))
+	if b.compressed {
+		b.positions = nil
+		return compress(data)
+	}
+
+	b.positions = RecordPositions(data)
+	return data, nil
+}
+
+//Positions returns the []int{offset, length, checksum} of each record in the block file
+//as of the last time it was loaded or encoded
+func (b *Block) Positions() map[string][]int {
+	return b.positions
+}
+
+//RecordPositions returns the []int{offset, length, checksum} of each record line in
+//block data written by Block.Encode. length excludes the trailing comma and
+//checksum is the positionSum of the line so positional reads can be verified
+func RecordPositions(data []byte) map[string][]int {
+	positions := map[string][]int{}
+	ndjson := detectFormat(data) == FormatNDJSON
+
+	offset := 0
+	for offset < len(data) {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data) - offset
+		}
+		line := data[offset : offset+end]
+
+		if ndjson {
+			if bytes.HasPrefix(line, []byte(`{"id":`)) {
+				if id, ok := entryKey(line[len(`{"id":`):]); ok {
+					positions[id] = []int{offset, len(line), positionSum(line)}
+				}
+			}
+			offset += end + 1
+			continue
+		}
+
+		start := bytes.IndexByte(line, '"')
+		if start >= 0 && len(bytes.TrimSpace(line[:start])) == 0 {
+			entry := bytes.TrimSuffix(line[start:], []byte(","))
+			if recordID, ok := entryKey(entry); ok && !isChecksumKey(recordID) {
+				positions[recordID] = []int{offset + start, len(entry), positionSum(entry)}
+			}
+		}
+
+		offset += end + 1
+	}
+
+	return positions
+}
+
+//positionSum returns the checksum of the bytes of a record at its position in a block file
+func positionSum(entry []byte) int {
+	return int(int32(crc32.ChecksumIEEE(entry)))
+}
+
+//entryKey returns the leading JSON string of a `"key": "value"` block entry
+func entryKey(entry []byte) (string, bool) {
+	for i := 1; i < len(entry); i++ {
+		switch entry[i] {
+		case '\\':
+			i++
+		case '"':
+			var key string
+			if err := json.Unmarshal(entry[:i+1], &key); err != nil {
+				return "", false
+			}
+			return key, true
+		}
+	}
+
+	return "", false
 }
 
 //MarshalJSON implements json.MarshalJSON
@@ -138,22 +322,39 @@ func (b *Block) MarshalJSON() ([]byte, error) {
 	raw := map[string]string{}
 	for k, v := range b.records {
 		raw[k] = v.data
+		raw[k+checksumSuffix] = recordSum(k, v.data)
 	}
 
 	return json.Marshal(raw)
 }
 
 //UnmarshalJSON implements json.UnmarshalJSON
+//records written before checksums were introduced are accepted as is
 func (b *Block) UnmarshalJSON(data []byte) error {
 	var raw map[string]string
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return err
 	}
 
+	for k, v := range raw {
+		if isChecksumKey(k) {
+			//a checksum without its record means the record was lost
+			if _, ok := raw[strings.TrimSuffix(k, checksumSuffix)]; !ok {
+				return errChecksumMismatch
+			}
+			continue
+		}
+
+		if sum, ok := raw[k+checksumSuffix]; ok && sum != recordSum(k, v) {
+			return errChecksumMismatch
+		}
+	}
+
 	//populate recs
 	for k, v := range raw {
-		r := newRecord(k, v)
-		b.records[k] = r
+		if !isChecksumKey(k) {
+			b.records[k] = newRecord(k, v)
+		}
 	}
 
 	return nil
@@ -167,8 +368,20 @@ func (b *Block) load() error {
 		return err
 	}
 
+	b.compressed = isCompressed(data)
+	if data, err = uncompress(data); err != nil {
+		return corruptBlock(blockFile, err)
+	}
+
 	b.size = int64(len(data))
-	return json.Unmarshal(data, b)
+	if err := b.decode(data); err != nil {
+		return corruptBlock(blockFile, err)
+	}
+
+	if !b.compressed {
+		b.positions = RecordPositions(data)
+	}
+	return nil
 }
 
 //Record returns record in specifed index i
@@ -186,7 +399,7 @@ func (b *Block) Add(recordID, value string) {
 //Get a record by key from a Block
 func (b *Block) Get(key string) (*Record, error) {
 	if _, ok := b.records[key]; ok {
-		b.records[key].key = b.key
+		b.records[key].keys = b.keys
 		return b.records[key], nil
 	}
 
@@ -213,7 +426,7 @@ func (b *Block) Len() int {
 func (b *Block) Records() []*Record {
 	var records []*Record
 	for _, v := range b.records {
-		v.decrypt(b.key)
+		v.decrypt(b.keys)
 		records = append(records, v)
 	}
 
@@ -222,6 +435,17 @@ func (b *Block) Records() []*Record {
 	return records
 }
 
+//RecordIDs returns the ids of all Records in a Block sorted in asc order
+func (b *Block) RecordIDs() []string {
+	ids := make([]string, 0, len(b.records))
+	for id := range b.records {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
 func (b *Block) Filter(recordIDs map[string]string) {
 	records := make(map[string]*Record, len(recordIDs))
 	for recordID, value := range b.records {
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+)
+
+//CompactPolicy sets the size of the blocks Compact writes.
+//A block is closed when it reaches either limit. Zero means no limit
+type CompactPolicy struct {
+	MaxRecords int
+	MaxBytes   int64
+}
+
+//Compact rewrites the blocks of the dataset at datasetPath into blocks b0, b1, ...
+//filled up to policy in the order of the existing blocks. It returns the old and new
+//ids of the records that moved. Blocks are written in format or, if format is empty,
+//in the format of the first existing block, and compressed if the first existing
+//block is. Records are copied as stored. If before is not nil it is called with
+//each block file before it is written or removed and an error from it stops compaction
+func Compact(datasetPath string, policy CompactPolicy, format Format, before func(blockFile string) error) (map[string]string, error) {
+	if policy.MaxRecords <= 0 && policy.MaxBytes <= 0 {
+		return nil, errors.New("compact policy must limit records or bytes per block")
+	}
+
+	files, err := ioutil.ReadDir(datasetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var blockFiles []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
+			blockFiles = append(blockFiles, filepath.Join(datasetPath, file.Name()))
+		}
+	}
+	sort.Slice(blockFiles, func(i, j int) bool {
+		return blockLess(blockName(blockFiles[i]), blockName(blockFiles[j]))
+	})
+
+	//read every block before anything is written so that a bad block aborts compaction
+	var blocks []*Block
+	for _, blockFile := range blockFiles {
+		block, err := OpenBlock(blockFile, nil)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	if len(format) == 0 {
+		format = FormatJSON
+		if len(blocks) > 0 {
+			format = blocks[0].Format()
+		}
+	}
+	compressed := len(blocks) > 0 && blocks[0].Compressed()
+
+	moved := map[string]string{}
+	var newBlocks []*Block
+	var current *Block
+	var currentBytes int64
+	for _, block := range blocks {
+		for _, id := range block.RecordIDs() {
+			data := block.records[id].data
+			size := recordSize(format, id, data)
+
+			full := current != nil && current.Len() > 0 &&
+				((policy.MaxRecords > 0 && current.Len() >= policy.MaxRecords) ||
+					(policy.MaxBytes > 0 && currentBytes+size > policy.MaxBytes))
+			if current == nil || full {
+				name := fmt.Sprintf("b%d", len(newBlocks))
+				current = NewBlock(filepath.Join(datasetPath, name+".json"), nil)
+				current.SetFormat(format)
+				current.SetCompressed(compressed)
+				newBlocks = append(newBlocks, current)
+				currentBytes = 0
+			}
+
+			newID := id
+			if parts := strings.SplitN(id, "/", 3); len(parts) == 3 {
+				newID = parts[0] + "/" + blockName(current.path) + "/" + parts[2]
+			}
+			if newID != id {
+				moved[id] = newID
+			}
+
+			current.Add(newID, data)
+			currentBytes += size
+		}
+	}
+
+	keep := map[string]bool{}
+	for _, block := range newBlocks {
+		data, err := block.Encode()
+		if err != nil {
+			return moved, err
+		}
+		if before != nil {
+			if err := before(block.path); err != nil {
+				return moved, err
+			}
+		}
+		if err := WriteFileAtomic(block.path, data, 0744); err != nil {
+			return moved, err
+		}
+		keep[block.path] = true
+	}
+
+	for _, blockFile := range blockFiles {
+		if !keep[blockFile] {
+			if before != nil {
+				if err := before(blockFile); err != nil {
+					return moved, err
+				}
+			}
+			if err := os.Remove(blockFile); err != nil {
+				return moved, err
+			}
+		}
+	}
+
+	return moved, nil
+}
+
+//NewBlock constructs an empty block to be written to blockFilePath
+func NewBlock(blockFilePath string, keys crypto.Keyring) *Block {
+	return &Block{
+		path:       blockFilePath,
+		keys:       keys,
+		records:    map[string]*Record{},
+		badRecords: []string{},
+		dataset:    &Dataset{path: filepath.Dir(blockFilePath), keys: keys},
+	}
+}
+
+//recordSize returns the number of bytes a record takes up in a block file of format
+func recordSize(format Format, id, data string) int64 {
+	if format == FormatNDJSON {
+		return int64(len(encodeLine(id, data)) + 1)
+	}
+
+	idBytes, _ := json.Marshal(id)
+	dataBytes, _ := json.Marshal(data)
+	sumKeyBytes, _ := json.Marshal(id + checksumSuffix)
+	sumBytes, _ := json.Marshal(recordSum(id, data))
+	//tab, colon, space, comma and newline of the record and of its checksum
+	return int64(len(idBytes) + len(dataBytes) + 5 + len(sumKeyBytes) + len(sumBytes) + 5)
+}
+
+//blockName returns the block id of a block file
+func blockName(blockFile string) string {
+	return strings.TrimSuffix(filepath.Base(blockFile), filepath.Ext(blockFile))
+}
+
+//blockLess orders block ids naturally so that b2 comes before b10
+func blockLess(a, b string) bool {
+	ap, an := splitBlockName(a)
+	bp, bn := splitBlockName(b)
+	if ap == bp && an >= 0 && bn >= 0 && an != bn {
+		return an < bn
+	}
+
+	return a < b
+}
+
+//splitBlockName splits a block id into its prefix and numeric suffix, -1 if it has none
+func splitBlockName(name string) (string, int) {
+	i := len(name)
+	for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+		i--
+	}
+
+	n, err := strconv.Atoi(name[i:])
+	if err != nil {
+		return name, -1
+	}
+
+	return name[:i], n
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+)
+
+//gzipHeader is the magic number every gzip compressed block file starts with.
+//Uncompressed block files always start with '{' or whitespace
+var gzipHeader = []byte{0x1f, 0x8b}
+
+//isCompressed reports whether block file data is gzip compressed
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, gzipHeader)
+}
+
+//compress gzips block file data
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+//uncompress returns block file data as written by Encode before compression
+func uncompress(data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+//Compressed reports whether the block was read or will be written gzip compressed
+func (b *Block) Compressed() bool {
+	return b.compressed
+}
+
+//SetCompressed sets whether Encode gzip compresses the block
+func (b *Block) SetCompressed(compressed bool) {
+	b.compressed = compressed
+}
+
+//Size returns the uncompressed size of the block as of the last time it was loaded or encoded
+func (b *Block) Size() int64 {
+	return b.size
+}
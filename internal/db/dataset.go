@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/digital"
 )
 
@@ -19,14 +20,14 @@ type Dataset struct {
 	badRecords   []string
 	lastModified time.Time
 
-	key string
+	keys crypto.Keyring
 }
 
 //LoadDataset loads the dataset at path
-func LoadDataset(datasetPath, key string) *Dataset {
+func LoadDataset(datasetPath string, keys crypto.Keyring) *Dataset {
 	ds := &Dataset{
 		path: datasetPath,
-		key:  key,
+		keys: keys,
 	}
 	ds.loadBlocks()
 
@@ -34,7 +35,7 @@ func LoadDataset(datasetPath, key string) *Dataset {
 }
 
 //LoadDatasets loads all datasets in given gitdb path
-func LoadDatasets(dbPath, key string) []*Dataset {
+func LoadDatasets(dbPath string, keys crypto.Keyring) []*Dataset {
 	var datasets []*Dataset
 
 	dirs, err := ioutil.ReadDir(dbPath)
@@ -48,7 +49,7 @@ func LoadDatasets(dbPath, key string) []*Dataset {
 			ds := &Dataset{
 				path:         filepath.Join(dbPath, dir.Name()),
 				lastModified: dir.ModTime(),
-				key:          key,
+				keys:         keys,
 			}
 
 			datasets = append(datasets, ds)
@@ -163,7 +164,7 @@ func (d *Dataset) loadBlocks() {
 
 	for _, blk := range blks {
 		if !blk.IsDir() && strings.HasSuffix(blk.Name(), ".json") {
-			b := LoadBlock(filepath.Join(d.path, blk.Name()), d.key)
+			b := LoadBlock(filepath.Join(d.path, blk.Name()), d.keys)
 			d.blocks = append(d.blocks, b)
 		}
 	}
@@ -180,6 +181,9 @@ func (d *Dataset) Indexes() []string {
 	}
 
 	for _, indexFile := range indexFiles {
+		if indexFile.IsDir() {
+			continue
+		}
 		indexes = append(indexes, strings.TrimSuffix(indexFile.Name(), ".json"))
 	}
 
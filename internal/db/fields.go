@@ -0,0 +1,209 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/errors"
+)
+
+//encryptedKey is the key of record data listing the paths of encrypted fields.
+//It is reserved so that it does not clash with a field of a model
+const encryptedKey = "_encrypted"
+
+//EncryptFields replaces the values at paths in record data with their JSON encoding
+//encrypted by encrypt and lists the paths under "_encrypted" so they can be decrypted
+//on read. Paths are relative to the model i.e. the "Data" of v2 records
+func EncryptFields(data string, paths [][]string, encrypt func(string) (string, error)) (string, error) {
+	record, err := decodeObject(data)
+	if err != nil {
+		return "", err
+	}
+
+	fields := record
+	if _, ok := record["Version"]; ok {
+		if fields, ok = record["Data"].(map[string]interface{}); !ok {
+			return "", fmt.Errorf("record has no data")
+		}
+	}
+
+	var encrypted [][]string
+	for _, path := range paths {
+		parent, key := lookupParent(fields, path)
+		if parent == nil || parent[key] == nil {
+			continue
+		}
+
+		value, err := json.Marshal(parent[key])
+		if err != nil {
+			return "", err
+		}
+
+		if parent[key], err = encrypt(string(value)); err != nil {
+			return "", err
+		}
+		encrypted = append(encrypted, path)
+	}
+
+	if len(encrypted) == 0 {
+		return data, nil
+	}
+
+	record[encryptedKey] = encrypted
+	b, err := json.Marshal(record)
+	return string(b), err
+}
+
+//decryptFields returns record data with the fields listed under "_encrypted" decrypted
+//with keys. Data without encrypted fields is returned unchanged
+func decryptFields(data string, keys crypto.Keyring, dataset string) (string, error) {
+	record, paths := encryptedPaths(data)
+	if len(paths) == 0 {
+		return data, nil
+	}
+
+	if keys == nil {
+		return "", fmt.Errorf("%w: record has encrypted fields and no key is configured", errors.ErrDecryptionFailed)
+	}
+
+	fields := record
+	if _, ok := record["Version"]; ok {
+		fields, _ = record["Data"].(map[string]interface{})
+	}
+
+	for _, path := range paths {
+		parent, key := lookupParent(fields, path)
+		if parent == nil {
+			continue
+		}
+
+		secret, ok := parent[key].(string)
+		if !ok {
+			continue
+		}
+
+		plain, err := crypto.DecryptWith(keys, dataset, secret)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+
+		if parent[key], err = decodeValue(plain); err != nil {
+			return "", err
+		}
+	}
+
+	delete(record, encryptedKey)
+	b, err := json.Marshal(record)
+	return string(b), err
+}
+
+//rotateFields re-encrypts the encrypted fields of record data of dataset, decrypted
+//with the keys in old, with key and reports whether any field was changed.
+//Fields already encrypted with key are skipped
+func rotateFields(data string, dataset string, old crypto.Keyring, keyID, key string) (string, bool, error) {
+	record, paths := encryptedPaths(data)
+	if len(paths) == 0 {
+		return data, false, nil
+	}
+
+	fields := record
+	if _, ok := record["Version"]; ok {
+		fields, _ = record["Data"].(map[string]interface{})
+	}
+
+	changed := false
+	for _, path := range paths {
+		parent, name := lookupParent(fields, path)
+		if parent == nil {
+			continue
+		}
+
+		secret, ok := parent[name].(string)
+		if !ok {
+			continue
+		}
+
+		//rotated by an earlier run
+		if crypto.KeyID(secret) == keyID {
+			if _, err := crypto.Decrypt(key, secret); err == nil {
+				continue
+			}
+		}
+
+		plain, err := crypto.DecryptWith(old, dataset, secret)
+		if err != nil {
+			return data, false, fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+
+		if parent[name], err = crypto.Encrypt(key, keyID, plain); err != nil {
+			return data, false, err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return data, false, nil
+	}
+
+	b, err := json.Marshal(record)
+	return string(b), true, err
+}
+
+//encryptedPaths returns the decoded record data and the paths listed under "_encrypted"
+//at the top level of the record. It returns no paths if data has no encrypted fields
+func encryptedPaths(data string) (map[string]interface{}, [][]string) {
+	//skip decoding records that cannot have encrypted fields
+	if !strings.Contains(data, `"`+encryptedKey+`"`) {
+		return nil, nil
+	}
+
+	record, err := decodeObject(data)
+	if err != nil || record[encryptedKey] == nil {
+		return nil, nil
+	}
+
+	var paths [][]string
+	raw, err := json.Marshal(record[encryptedKey])
+	if err != nil || json.Unmarshal(raw, &paths) != nil {
+		return nil, nil
+	}
+
+	return record, paths
+}
+
+//lookupParent returns the object holding the last key of path in obj or nil if there is none
+func lookupParent(obj map[string]interface{}, path []string) (map[string]interface{}, string) {
+	if len(path) == 0 {
+		return nil, ""
+	}
+
+	for _, key := range path[:len(path)-1] {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		obj = child
+	}
+
+	return obj, path[len(path)-1]
+}
+
+func decodeObject(data string) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func decodeValue(data string) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(data))
+	d.UseNumber()
+	err := d.Decode(&v)
+	return v, err
+}
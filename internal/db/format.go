@@ -0,0 +1,230 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//Format is the on-disk layout of a block file
+type Format string
+
+const (
+	//FormatJSON stores a block as a JSON object of record id => record data string
+	FormatJSON Format = "json"
+	//FormatNDJSON stores a block as one record per line sorted by id with the
+	//record data unescaped so that git diffs and merges work per record,
+	//followed by a trailer line that marks the end of the block
+	FormatNDJSON Format = "ndjson"
+)
+
+//ndjsonEntry is a line of a FormatNDJSON block file
+type ndjsonEntry struct {
+	ID   string          `json:"id"`
+	Sum  string          `json:"sum"`
+	Data json.RawMessage `json:"data"`
+}
+
+//ndjsonTrailer is the last line of a FormatNDJSON block file. Any truncation of the
+//block file removes it so a block that lost whole lines is not mistaken for a block
+//with fewer records. It is the same in every block so that it never conflicts when
+//git merges edits to different records
+const ndjsonTrailer = `{"end":true}`
+
+//detectFormat returns the Format of block file data
+func detectFormat(data []byte) Format {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return FormatNDJSON
+	}
+
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+
+	var entry map[string]json.RawMessage
+	if err := json.Unmarshal(line, &entry); err == nil {
+		_, hasID := entry["id"]
+		_, hasData := entry["data"]
+		_, hasEnd := entry["end"]
+		//a block without records only has a trailer
+		if hasID && hasData || hasEnd && !hasID {
+			return FormatNDJSON
+		}
+	}
+
+	return FormatJSON
+}
+
+//decode populates b with the records in block file data of either Format
+func (b *Block) decode(data []byte) error {
+	b.format = detectFormat(data)
+	if b.format == FormatJSON {
+		return json.Unmarshal(data, b)
+	}
+
+	raw := map[string]string{}
+	trailer := false
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if trailer {
+			return fmt.Errorf("line %d: record after trailer", i+1)
+		}
+
+		if string(bytes.TrimSpace(line)) == ndjsonTrailer {
+			trailer = true
+			continue
+		}
+
+		id, recordData, err := decodeLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", i+1, err)
+		}
+		raw[id] = recordData
+	}
+
+	if !trailer {
+		return fmt.Errorf("missing trailer")
+	}
+
+	for id, recordData := range raw {
+		b.records[id] = newRecord(id, recordData)
+	}
+
+	return nil
+}
+
+//encodeNDJSON returns the records of b in FormatNDJSON
+func (b *Block) encodeNDJSON() []byte {
+	ids := make([]string, 0, len(b.records))
+	for id := range b.records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.Write(encodeLine(id, b.records[id].data))
+		buf.WriteByte('\n')
+	}
+
+	buf.WriteString(ndjsonTrailer)
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+//encodeLine returns the FormatNDJSON line of a record. Record data that is a JSON
+//object is written as is while anything else, e.g. encrypted data, is written as a string
+func encodeLine(id, data string) []byte {
+	idBytes, _ := json.Marshal(id)
+	sumBytes, _ := json.Marshal(recordSum(id, data))
+
+	raw := []byte(data)
+	if len(raw) == 0 || raw[0] != '{' || bytes.IndexByte(raw, '\n') >= 0 || !json.Valid(raw) {
+		raw, _ = json.Marshal(data)
+	}
+
+	line := []byte(`{"id":`)
+	line = append(line, idBytes...)
+	line = append(line, `,"sum":`...)
+	line = append(line, sumBytes...)
+	line = append(line, `,"data":`...)
+	line = append(line, raw...)
+	return append(line, '}')
+}
+
+//decodeLine parses a FormatNDJSON line verifying its checksum
+func decodeLine(line []byte) (string, string, error) {
+	var entry ndjsonEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return "", "", err
+	}
+
+	data := string(entry.Data)
+	if len(entry.Data) > 0 && entry.Data[0] == '"' {
+		if err := json.Unmarshal(entry.Data, &data); err != nil {
+			return "", "", err
+		}
+	}
+
+	if len(entry.ID) == 0 || entry.Sum != recordSum(entry.ID, data) {
+		return "", "", errChecksumMismatch
+	}
+
+	return entry.ID, data, nil
+}
+
+//lineSum returns the checksum of a record in a FormatNDJSON block
+func recordSum(id, data string) string {
+	h := crc32.NewIEEE()
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write([]byte(data))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+//ConvertBlocks rewrites every block file of every dataset in dbDir in format
+//and returns the paths of the block files it converted. Records are copied
+//as stored so encrypted records do not need to be decrypted
+func ConvertBlocks(dbDir string, format Format) ([]string, error) {
+	if format != FormatJSON && format != FormatNDJSON {
+		return nil, fmt.Errorf("unknown block format: %s", format)
+	}
+
+	dirs, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var converted []string
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+
+		datasetPath := filepath.Join(dbDir, dir.Name())
+		files, err := ioutil.ReadDir(datasetPath)
+		if err != nil {
+			return converted, err
+		}
+
+		for _, file := range files {
+			blockFile := filepath.Join(datasetPath, file.Name())
+			if file.IsDir() || filepath.Ext(blockFile) != ".json" {
+				continue
+			}
+
+			block, err := OpenBlock(blockFile, nil)
+			if err != nil {
+				return converted, err
+			}
+
+			if block.Format() == format {
+				continue
+			}
+
+			block.SetFormat(format)
+			data, err := block.Encode()
+			if err != nil {
+				return converted, err
+			}
+
+			if err := WriteFileAtomic(blockFile, data, 0744); err != nil {
+				return converted, err
+			}
+			converted = append(converted, blockFile)
+		}
+	}
+
+	return converted, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2/internal/crypto"
@@ -13,10 +14,11 @@ import (
 //Record represents a model stored in gitdb
 type Record struct {
 	id   string
-	data string
-	key  string
+	data string //as stored in the block file
+	keys crypto.Keyring
 
 	p         fastjson.Parser
+	plain     string //data decrypted
 	decrypted bool
 }
 
@@ -25,6 +27,11 @@ func newRecord(id, data string) *Record {
 	return &Record{id: id, data: data}
 }
 
+//NewRecord constructs a Record of data as it is stored in a block
+func NewRecord(id, data string) *Record {
+	return newRecord(id, data)
+}
+
 //ID returns record id
 func (r *Record) ID() string {
 	return r.id
@@ -37,16 +44,18 @@ func (r *Record) Data() string {
 
 //Hydrate populates given interfacce with underlying record data
 func (r *Record) Hydrate(model interface{}) error {
-	r.decrypt(r.key)
+	if err := r.decrypt(r.keys); err != nil {
+		return err
+	}
 	version := r.Version()
 	switch version {
 	case "v1":
-		if err := json.Unmarshal([]byte(r.data), model); err != nil {
+		if err := json.Unmarshal([]byte(r.text()), model); err != nil {
 			return err
 		}
 		return nil
 	case "v2": //TODO Optimize Unmarshall-Marshall technique
-		v, err := r.p.Parse(r.data)
+		v, err := r.p.Parse(r.text())
 		if err != nil {
 			return err
 		}
@@ -63,31 +72,111 @@ func (r *Record) Hydrate(model interface{}) error {
 	}
 }
 
-func (r *Record) decrypt(key string) {
-	if len(key) > 0 && !r.decrypted {
-		log.Test("decrypting with: " + key)
-		dec := crypto.Decrypt(key, r.data)
-		if len(dec) > 0 {
-			r.data = dec
+//Value returns the value at path in the record data without hydrating a model.
+//Numbers are returned as json.Number, objects and arrays as raw JSON
+//and a missing path as nil
+func (r *Record) Value(path ...string) (interface{}, error) {
+	if err := r.decrypt(r.keys); err != nil {
+		return nil, err
+	}
+	v, err := r.p.Parse(r.text())
+	if err != nil {
+		return nil, err
+	}
+
+	if string(v.GetStringBytes("Version")) == "v2" {
+		v = v.Get("Data")
+	}
+
+	v = v.Get(path...)
+	if v == nil {
+		return nil, nil
+	}
+
+	switch v.Type() {
+	case fastjson.TypeNull:
+		return nil, nil
+	case fastjson.TypeString:
+		return string(v.GetStringBytes()), nil
+	case fastjson.TypeNumber:
+		return json.Number(v.String()), nil
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		return v.GetBool(), nil
+	}
+
+	return v.String(), nil
+}
+
+//decrypt decrypts the record and its encrypted fields returning an error
+//wrapping ErrDecryptionFailed if keys cannot decrypt them. The stored data
+//is kept as is so that writing the block does not write decrypted records
+func (r *Record) decrypt(keys crypto.Keyring) error {
+	if r.decrypted {
+		return nil
+	}
+
+	dataset := strings.SplitN(r.id, "/", 2)[0]
+	plain := r.data
+	if crypto.IsEncrypted(plain) {
+		if keys == nil {
+			return nil
 		}
-		r.decrypted = true
+
+		var err error
+		if plain, err = crypto.DecryptWith(keys, dataset, plain); err != nil {
+			return fmt.Errorf("%s: %w", r.id, err)
+		}
+	}
+
+	plain, err := decryptFields(plain, keys, dataset)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.id, err)
+	}
+
+	r.plain = plain
+	r.decrypted = true
+	return nil
+}
+
+//text returns the record data decrypted if it has been
+func (r *Record) text() string {
+	if r.decrypted {
+		return r.plain
 	}
+	return r.data
 }
 
 //JSON returns data decrypted and indented
 func (r *Record) JSON() string {
 	var buf bytes.Buffer
-	r.decrypt(r.key)
-	if err := json.Indent(&buf, []byte(r.data), "", "\t"); err != nil {
+	if err := r.decrypt(r.keys); err != nil {
+		log.Error(err.Error())
+		return r.data
+	}
+	if err := json.Indent(&buf, []byte(r.text()), "", "\t"); err != nil {
 		log.Error(err.Error())
 	}
 
 	return buf.String()
 }
 
+//Revision returns the number of times the record has been written.
+//Records written before records had revisions are at revision 0
+func (r *Record) Revision() (int64, error) {
+	if err := r.decrypt(r.keys); err != nil {
+		return 0, err
+	}
+	v, err := r.p.Parse(r.text())
+	if err != nil {
+		return 0, err
+	}
+
+	return v.GetInt64("Revision"), nil
+}
+
 //Version returns the version of the record
 func (r *Record) Version() string {
-	v, err := r.p.Parse(r.data)
+	v, err := r.p.Parse(r.text())
 	if err != nil {
 		return "v1"
 	}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+)
+
+//RotateKey re-encrypts every encrypted record and field of every dataset in dbDir, decrypted
+//with the keys in old, with the current key of its dataset in keys and returns the
+//paths of the block files it rewrote. Records already encrypted with the current key
+//are skipped so an interrupted rotation can be run again to resume it. If before is not nil
+//it is called with each block file before it is rewritten and an error from it stops the rotation
+func RotateKey(dbDir string, old crypto.Keyring, keys crypto.Keychain, before func(blockFile string) error) ([]string, error) {
+	dirs, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var rotated []string
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+
+		dataset := dir.Name()
+		keyID, key, err := keys.CurrentKey(dataset)
+		if err != nil {
+			return rotated, fmt.Errorf("no current key for %s: %s", dataset, err)
+		}
+
+		//fail before touching any block of the dataset if the current key is invalid
+		if _, err := crypto.Encrypt(key, keyID, ""); err != nil {
+			return rotated, err
+		}
+
+		datasetPath := filepath.Join(dbDir, dataset)
+		files, err := ioutil.ReadDir(datasetPath)
+		if err != nil {
+			return rotated, err
+		}
+
+		for _, file := range files {
+			blockFile := filepath.Join(datasetPath, file.Name())
+			if file.IsDir() || filepath.Ext(blockFile) != ".json" {
+				continue
+			}
+
+			block, err := OpenBlock(blockFile, nil)
+			if err != nil {
+				return rotated, err
+			}
+
+			changed, err := block.rotateKey(dataset, old, keyID, key)
+			if err != nil {
+				return rotated, err
+			}
+
+			if !changed {
+				continue
+			}
+
+			data, err := block.Encode()
+			if err != nil {
+				return rotated, err
+			}
+
+			if before != nil {
+				if err := before(blockFile); err != nil {
+					return rotated, err
+				}
+			}
+			if err := WriteFileAtomic(blockFile, data, 0744); err != nil {
+				return rotated, err
+			}
+			rotated = append(rotated, blockFile)
+		}
+	}
+
+	return rotated, nil
+}
+
+//rotateKey re-encrypts the encrypted records and fields of b with key
+//and reports whether any record was changed
+func (b *Block) rotateKey(dataset string, old crypto.Keyring, keyID, key string) (bool, error) {
+	changed := false
+	for _, id := range b.RecordIDs() {
+		record := b.records[id]
+		if !crypto.IsEncrypted(record.data) {
+			data, fieldsChanged, err := rotateFields(record.data, dataset, old, keyID, key)
+			if err != nil {
+				return changed, fmt.Errorf("%s: %w", id, err)
+			}
+			record.data = data
+			changed = changed || fieldsChanged
+			continue
+		}
+
+		//rotated by an earlier run
+		if crypto.KeyID(record.data) == keyID {
+			if _, err := crypto.Decrypt(key, record.data); err == nil {
+				continue
+			}
+		}
+
+		data, err := crypto.DecryptWith(old, dataset, record.data)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", id, err)
+		}
+
+		if record.data, err = crypto.Encrypt(key, keyID, data); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
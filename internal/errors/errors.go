@@ -11,12 +11,17 @@ var (
 	errConnectionInvalid = errors.New("gitDB: connection is not valid. use gitdb.Start to construct a valid connection")
 
 	//external errors
-	ErrNoRecords       = errors.New("gitDB: no records found")
-	ErrRecordNotFound  = errors.New("gitDB: record not found")
-	ErrInvalidRecordID = errors.New("gitDB: invalid record id")
-	ErrDBSyncFailed    = errors.New("gitDB: Database sync failed")
-	ErrLowBattery      = errors.New("gitDB: Insufficient battery power. Syncing disabled")
-	ErrNoOnlineRemote  = errors.New("gitDB: Online remote is not set. Syncing disabled")
-	ErrAccessDenied    = errors.New("gitDB: Access was denied to online repository")
-	ErrInvalidDataset  = errors.New("gitDB: invalid dataset. Dataset not in registry")
+	ErrNoRecords        = errors.New("gitDB: no records found")
+	ErrRecordNotFound   = errors.New("gitDB: record not found")
+	ErrInvalidRecordID  = errors.New("gitDB: invalid record id")
+	ErrDBSyncFailed     = errors.New("gitDB: Database sync failed")
+	ErrLowBattery       = errors.New("gitDB: Insufficient battery power. Syncing disabled")
+	ErrNoOnlineRemote   = errors.New("gitDB: Online remote is not set. Syncing disabled")
+	ErrAccessDenied     = errors.New("gitDB: Access was denied to online repository")
+	ErrInvalidDataset   = errors.New("gitDB: invalid dataset. Dataset not in registry")
+	ErrUniqueViolation  = errors.New("gitDB: unique index violation")
+	ErrCorruptBlock     = errors.New("gitDB: corrupt block - checksum mismatch or truncated data")
+	ErrDecryptionFailed = errors.New("gitDB: decryption failed - wrong encryption key or tampered record")
+	ErrConflict         = errors.New("gitDB: conflict - data was changed by another write")
+	ErrCommitPending    = errors.New("gitDB: write stored but not committed before the context was done")
 )
@@ -0,0 +1,79 @@
+package fts
+
+import (
+	"strings"
+	"unicode"
+)
+
+//stopWords are common english words that are not indexed
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true,
+	"to": true, "was": true, "will": true, "with": true,
+}
+
+//Tokenize splits text into lower case, stemmed terms with stop words removed
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var terms []string
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		terms = append(terms, Stem(word))
+	}
+
+	return terms
+}
+
+//Stem reduces a lower case word to its stem by stripping common english
+//plural suffixes followed by common verb and adverb suffixes
+func Stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"):
+		word = strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		word = strings.TrimSuffix(word, "s")
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ing") && len(word) > 5:
+		word = undouble(strings.TrimSuffix(word, "ing"))
+	case strings.HasSuffix(word, "ed") && len(word) > 4:
+		word = undouble(strings.TrimSuffix(word, "ed"))
+	case strings.HasSuffix(word, "ly") && len(word) > 4:
+		word = strings.TrimSuffix(word, "ly")
+	}
+
+	return word
+}
+
+//undouble removes the last letter of a stem ending in a double consonant e.g runn => run
+func undouble(stem string) string {
+	n := len(stem)
+	if n < 2 || stem[n-1] != stem[n-2] {
+		return stem
+	}
+
+	switch stem[n-1] {
+	case 'a', 'e', 'i', 'o', 'u', 'l', 's', 'z':
+		return stem
+	}
+
+	return stem[:n-1]
+}
@@ -0,0 +1,124 @@
+package gitdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//journal records the content of files before a transaction first changes them
+//so that a transaction that fails part way through can put back exactly the files it changed
+type journal struct {
+	files  map[string][]byte //path => content before the transaction, nil if it did not exist
+	order  []string
+	parent *journal //journal of the operations the operations of this journal run within
+}
+
+func newJournal() *journal {
+	return &journal{files: map[string][]byte{}}
+}
+
+//record saves the content of path if it is about to be changed for the first time
+func (j *journal) record(path string) error {
+	if _, ok := j.files[path]; ok {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to journal %s: %w", path, err)
+	}
+	if data == nil && err == nil {
+		data = []byte{}
+	}
+
+	j.files[path] = data
+	j.order = append(j.order, path)
+	return nil
+}
+
+//merge adds the files recorded in from that j has not recorded
+func (j *journal) merge(from *journal) {
+	for _, path := range from.order {
+		if _, ok := j.files[path]; !ok {
+			j.files[path] = from.files[path]
+			j.order = append(j.order, path)
+		}
+	}
+}
+
+//rollback restores every file recorded in j along with the block
+//cache and index entries of restored blocks. g.writeMu must be held
+func (g *gitdb) rollback(j *journal) error {
+	var failed []string
+	for i := len(j.order) - 1; i >= 0; i-- {
+		path := j.order[i]
+		if err := g.restoreFile(path, j.files[path]); err != nil {
+			log.Error(err.Error())
+			failed = append(failed, path)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore %s", strings.Join(failed, ", "))
+	}
+
+	log.Info(fmt.Sprintf("rolled back %d files", len(j.order)))
+	return nil
+}
+
+//restoreFile puts back data as the content of path, removing path if data is nil.
+//Block files are also dropped from the block cache and their records re-indexed.
+//g.writeMu must be held
+func (g *gitdb) restoreFile(path string, data []byte) error {
+	isBlock := filepath.Ext(path) == ".json" && strings.HasPrefix(path, g.dbDir()+string(filepath.Separator))
+
+	//ids of records written by the transaction
+	var written []string
+	if isBlock {
+		delete(g.loadedBlocks, path)
+		if block, err := db.OpenBlock(path, nil); err == nil {
+			written = block.RecordIDs()
+		}
+	}
+
+	if data == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := db.WriteFileAtomic(path, data, 0744); err != nil {
+		return err
+	}
+
+	if !isBlock {
+		return nil
+	}
+
+	dataset := filepath.Base(filepath.Dir(path))
+	restored := map[string]bool{}
+	if data != nil {
+		block, err := db.OpenBlock(path, g.config.keys())
+		if err != nil {
+			return err
+		}
+		for _, recordID := range block.RecordIDs() {
+			restored[recordID] = true
+		}
+		g.updateIndexes(block)
+	}
+
+	var removed []string
+	for _, recordID := range written {
+		if !restored[recordID] {
+			removed = append(removed, recordID)
+		}
+	}
+	g.removeFromIndexes(dataset, removed...)
+
+	return nil
+}
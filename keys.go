@@ -0,0 +1,220 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+)
+
+//KeyProvider supplies the keys records are encrypted with. Each dataset has a current
+//key that new records are encrypted with and the id of that key is stored with every
+//record so that records encrypted with older keys or keys of other datasets stay readable
+type KeyProvider interface {
+	//CurrentKey returns the id and key new records of dataset are encrypted with
+	CurrentKey(dataset string) (id string, key string, err error)
+	//Key returns the key with id that records of dataset were encrypted with.
+	//Records encrypted with Config.EncryptionKey have an empty id
+	Key(dataset string, id string) (string, error)
+}
+
+//keys returns the keys records are encrypted with or nil if encryption is not configured
+func (c *Config) keys() crypto.Keychain {
+	if c.KeyProvider != nil {
+		return c.KeyProvider
+	}
+
+	if len(c.EncryptionKey) > 0 {
+		return crypto.StaticKey(c.EncryptionKey)
+	}
+
+	return nil
+}
+
+//indexKey returns the key blind indexes are keyed with. It does not follow the current
+//key of a KeyProvider so that records indexed before the current key changed are still found
+func (c *Config) indexKey() (string, error) {
+	if len(c.IndexKey) > 0 {
+		return c.IndexKey, nil
+	}
+
+	if c.KeyProvider != nil {
+		return "", errors.New("Config.IndexKey must be set to index encrypted fields with a KeyProvider")
+	}
+
+	if len(c.EncryptionKey) > 0 {
+		return c.EncryptionKey, nil
+	}
+
+	return "", errors.New("Config.EncryptionKey or Config.IndexKey must be set to index encrypted fields")
+}
+
+//encrypt encrypts data of dataset with the current key of dataset
+func (g *gitdb) encrypt(dataset, data string) (string, error) {
+	keys := g.config.keys()
+	if keys == nil {
+		return "", errors.New("Config.EncryptionKey or Config.KeyProvider must be set to encrypt records")
+	}
+
+	id, key, err := keys.CurrentKey(dataset)
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.Encrypt(key, id, data)
+}
+
+type envKeyProvider struct {
+	prefix string
+}
+
+//NewEnvKeyProvider constructs a KeyProvider that reads keys from environment variables.
+//The key with id is read from <prefix>_KEY_<ID> and the key without an id from <prefix>_KEY.
+//The current key id of a dataset is read from <prefix>_<DATASET>_KEY_ID or else <prefix>_KEY_ID
+func NewEnvKeyProvider(prefix string) KeyProvider {
+	return &envKeyProvider{prefix: prefix}
+}
+
+func (p *envKeyProvider) CurrentKey(dataset string) (string, string, error) {
+	id, ok := os.LookupEnv(p.prefix + "_" + envName(dataset) + "_KEY_ID")
+	if !ok {
+		id = os.Getenv(p.prefix + "_KEY_ID")
+	}
+
+	key, err := p.Key(dataset, id)
+	return id, key, err
+}
+
+func (p *envKeyProvider) Key(dataset string, id string) (string, error) {
+	name := p.prefix + "_KEY"
+	if len(id) > 0 {
+		name += "_" + envName(id)
+	}
+
+	key := os.Getenv(name)
+	if len(key) == 0 {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+
+	return key, nil
+}
+
+//envName converts s to the form used in environment variable names
+func envName(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(s))
+}
+
+//fileKeyProvider holds keys read from a keyring file
+type fileKeyProvider struct {
+	Current  string            `json:"current"`
+	Datasets map[string]string `json:"datasets"`
+	Keys     map[string]string `json:"keys"`
+}
+
+//NewFileKeyProvider constructs a KeyProvider from a JSON keyring file with the keys by id,
+//the id of the current key and optionally the id of the current key of specific datasets
+//	{"current": "k2", "datasets": {"Accounts": "k3"}, "keys": {"k1": "...", "k2": "...", "k3": "..."}}
+//The file should only be readable by the user gitdb runs as
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &fileKeyProvider{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("invalid keyring file %s: %s", path, err)
+	}
+
+	return p, nil
+}
+
+func (p *fileKeyProvider) CurrentKey(dataset string) (string, string, error) {
+	id, ok := p.Datasets[dataset]
+	if !ok {
+		id = p.Current
+	}
+
+	key, err := p.Key(dataset, id)
+	return id, key, err
+}
+
+func (p *fileKeyProvider) Key(dataset string, id string) (string, error) {
+	key, ok := p.Keys[id]
+	if !ok {
+		return "", fmt.Errorf("key %q not in keyring", id)
+	}
+
+	return key, nil
+}
+
+//commandKeyProvider runs a command to get keys and caches them
+type commandKeyProvider struct {
+	name string
+	args []string
+
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+//NewCommandKeyProvider constructs a KeyProvider that gets keys from a command such as a secrets manager client.
+//The command is run as `name args... current <dataset>` and must print the id and key of the current
+//key of dataset separated by a space, and as `name args... key <dataset> <id>` and must print the key with id.
+//Keys are cached for the life of the KeyProvider
+func NewCommandKeyProvider(name string, args ...string) KeyProvider {
+	return &commandKeyProvider{name: name, args: args, keys: map[string]string{}}
+}
+
+func (p *commandKeyProvider) CurrentKey(dataset string) (string, string, error) {
+	out, err := p.cached("current\x00"+dataset, "current", dataset)
+	if err != nil {
+		return "", "", err
+	}
+
+	fields := strings.Fields(out)
+	switch len(fields) {
+	case 1:
+		return "", fields[0], nil
+	case 2:
+		return fields[0], fields[1], nil
+	}
+
+	return "", "", fmt.Errorf("%s current %s: want <id> <key>", p.name, dataset)
+}
+
+func (p *commandKeyProvider) Key(dataset string, id string) (string, error) {
+	return p.cached("key\x00"+dataset+"\x00"+id, "key", dataset, id)
+}
+
+//cached returns the output of the command run with args caching it by cacheKey
+func (p *commandKeyProvider) cached(cacheKey string, args ...string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if out, ok := p.keys[cacheKey]; ok {
+		return out, nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.name, append(append([]string{}, p.args...), args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %s %s", p.name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	key := strings.TrimSpace(string(out))
+	if len(key) == 0 {
+		return "", fmt.Errorf("%s %s returned no key", p.name, args[0])
+	}
+
+	p.keys[cacheKey] = key
+	return key, nil
+}
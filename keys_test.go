@@ -0,0 +1,127 @@
+package gitdb_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+const (
+	testKey1 = "11111111111111111111111111111111"
+	testKey2 = "22222222222222222222222222222222"
+)
+
+func TestFileKeyProvider(t *testing.T) {
+	keyring := filepath.Join(os.TempDir(), "gitdb-keyring.json")
+	defer os.Remove(keyring)
+
+	writeKeyring := func(current string) gitdb.KeyProvider {
+		data := `{"current": "` + current + `", "keys": {"k1": "` + testKey1 + `", "k2": "` + testKey2 + `"}}`
+		if err := ioutil.WriteFile(keyring, []byte(data), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %s", err)
+		}
+		keys, err := gitdb.NewFileKeyProvider(keyring)
+		if err != nil {
+			t.Fatalf("gitdb.NewFileKeyProvider failed: %s", err)
+		}
+		return keys
+	}
+
+	cfg := getConfig()
+	cfg.EncryptionKey = ""
+	cfg.KeyProvider = writeKeyring("k1")
+	teardown := setup(t, cfg)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//a new key generation
+	testDb.Close()
+	cfg.KeyProvider = writeKeyring("k2")
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Insert(getTestMessageWithId(2)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(cfg.DBPath, "data", "Message", "b0.json"))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+	for _, envelope := range []string{`"Message/b0/1": "gcm2:k1:`, `"Message/b0/2": "gcm2:k2:`} {
+		if !strings.Contains(string(data), envelope) {
+			t.Errorf("want %s in block: %s", envelope, data)
+		}
+	}
+
+	for _, id := range []string{"Message/b0/1", "Message/b0/2"} {
+		if err := testDb.Get(id, &Message{}); err != nil {
+			t.Errorf("testDb.Get(%s) failed: %s", id, err)
+		}
+	}
+
+	//k1 is no longer in the keyring
+	testDb.Close()
+	if err := ioutil.WriteFile(keyring, []byte(`{"current": "k2", "keys": {"k2": "`+testKey2+`"}}`), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+	cfg.KeyProvider, _ = gitdb.NewFileKeyProvider(keyring)
+	testDb = getDbConn(t, cfg)
+	testDb.RegisterModel("Message", &Message{})
+
+	if err := testDb.Get("Message/b0/1", &Message{}); !errors.Is(err, gitdb.ErrDecryptionFailed) {
+		t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrDecryptionFailed, err)
+	}
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	env := map[string]string{
+		"GITDB_TEST_KEY_ID":         "k1",
+		"GITDB_TEST_MESSAGE_KEY_ID": "k2",
+		"GITDB_TEST_KEY_K1":         testKey1,
+		"GITDB_TEST_KEY_K2":         testKey2,
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	keys := gitdb.NewEnvKeyProvider("GITDB_TEST")
+	tests := map[string][]string{"Message": {"k2", testKey2}, "Account": {"k1", testKey1}}
+	for dataset, want := range tests {
+		id, key, err := keys.CurrentKey(dataset)
+		if err != nil || id != want[0] || key != want[1] {
+			t.Errorf("CurrentKey(%s) want: %s %s, got: %s %s (%v)", dataset, want[0], want[1], id, key, err)
+		}
+	}
+
+	if _, err := keys.Key("Message", "k3"); err == nil {
+		t.Errorf("Key should fail for an unknown key id")
+	}
+}
+
+func TestCommandKeyProvider(t *testing.T) {
+	script := `if [ "$1" = current ]; then echo "k1 ` + testKey1 + `"; elif [ "$3" = k1 ]; then echo ` + testKey1 + `; else exit 1; fi`
+	keys := gitdb.NewCommandKeyProvider("sh", "-c", script, "sh")
+
+	id, key, err := keys.CurrentKey("Message")
+	if err != nil || id != "k1" || key != testKey1 {
+		t.Errorf("CurrentKey want: k1 %s, got: %s %s (%v)", testKey1, id, key, err)
+	}
+
+	if key, err := keys.Key("Message", "k1"); err != nil || key != testKey1 {
+		t.Errorf("Key want: %s, got: %s (%v)", testKey1, key, err)
+	}
+
+	if _, err := keys.Key("Message", "k2"); err == nil {
+		t.Errorf("Key should fail if the command fails")
+	}
+}
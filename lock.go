@@ -1,6 +1,7 @@
 package gitdb
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
@@ -17,6 +18,8 @@ func (g *gitdb) Lock(mo Model) error {
 		return errors.New("Model is not lockable")
 	}
 
+	defer g.enterWrite(context.Background())()
+
 	var lockFilesWritten []string
 
 	fullPath := g.lockDir(m)
@@ -40,6 +43,10 @@ func (g *gitdb) Lock(mo Model) error {
 			return errors.New("Lock file already exist: " + lockFile)
 		}
 
+		if err := g.journalWrite(lockFile); err != nil {
+			return err
+		}
+
 		err := ioutil.WriteFile(lockFile, []byte(""), 0644)
 		if err != nil {
 			if derr := g.deleteLockFiles(lockFilesWritten); derr != nil {
@@ -53,10 +60,11 @@ func (g *gitdb) Lock(mo Model) error {
 
 	g.commit.Add(1)
 	commitMsg := "Created Lock Files for: " + ID(m)
-	g.events <- newWriteEvent(commitMsg, fullPath, g.autoCommit)
+	e := newWriteEvent(commitMsg, fullPath, g.autoCommit())
+	g.events <- e
 
 	//block here until write has been committed
-	g.waitForCommit()
+	g.waitForCommit(e)
 	return nil
 }
 
@@ -67,6 +75,8 @@ func (g *gitdb) Unlock(mo Model) error {
 		return errors.New("Model is not lockable")
 	}
 
+	defer g.enterWrite(context.Background())()
+
 	fullPath := g.lockDir(m)
 
 	lockFiles := mo.(LockableModel).GetLockFileNames()
@@ -75,6 +85,9 @@ func (g *gitdb) Unlock(mo Model) error {
 
 		if _, err := os.Stat(lockFile); err == nil {
 			//log.PutInfo("Removing " + lockFile)
+			if err := g.journalWrite(lockFile); err != nil {
+				return err
+			}
 			err := os.Remove(lockFile)
 			if err != nil {
 				return errors.New("Could not delete lock file: " + lockFile)
@@ -84,13 +97,33 @@ func (g *gitdb) Unlock(mo Model) error {
 
 	g.commit.Add(1)
 	commitMsg := "Removing Lock Files for: " + ID(m)
-	g.events <- newWriteEvent(commitMsg, fullPath, g.autoCommit)
+	e := newWriteEvent(commitMsg, fullPath, g.autoCommit())
+	g.events <- e
 
 	//block here until write has been committed
-	g.waitForCommit()
+	g.waitForCommit(e)
 	return nil
 }
 
+//lockFiles returns the paths of the lock files of mo
+func (g *gitdb) lockFiles(mo Model) ([]string, error) {
+	lm, ok := mo.(LockableModel)
+	if !ok {
+		return nil, errors.New("Model is not lockable")
+	}
+
+	m := wrap(mo)
+	if !g.isRegistered(m.GetSchema().name()) {
+		return nil, ErrInvalidDataset
+	}
+
+	var lockFiles []string
+	for _, file := range lm.GetLockFileNames() {
+		lockFiles = append(lockFiles, filepath.Join(g.lockDir(m), file+".lock"))
+	}
+	return lockFiles, nil
+}
+
 func (g *gitdb) deleteLockFiles(files []string) error {
 	var err error
 	var failedDeletes []string
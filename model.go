@@ -1,6 +1,9 @@
 package gitdb
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
 	"time"
 )
 
@@ -20,6 +23,13 @@ type LockableModel interface {
 	GetLockFileNames() []string
 }
 
+//TimedModel is implemented by Models that set the time used by time based
+//block methods such as BlockByMonth
+type TimedModel interface {
+	//BlockTime returns the time that decides which block a Model is stored in
+	BlockTime() time.Time
+}
+
 //TimeStampedModel provides time stamp fields
 type TimeStampedModel struct {
 	CreatedAt time.Time
@@ -37,9 +47,19 @@ func (m *TimeStampedModel) BeforeInsert() error {
 	return nil
 }
 
+//BlockTime implements TimedModel using CreatedAt or the current time if it is not set yet
+func (m *TimeStampedModel) BlockTime() time.Time {
+	if m.CreatedAt.IsZero() {
+		return time.Now()
+	}
+
+	return m.CreatedAt
+}
+
 type model struct {
-	Version string
-	Data    Model
+	Version  string
+	Revision int64 //incremented every time the record is written
+	Data     Model
 }
 
 func wrap(m Model) *model {
@@ -49,8 +69,32 @@ func wrap(m Model) *model {
 	}
 }
 
+//setRevision returns data, the JSON of a *model, with its revision set to revision
+func setRevision(data string, revision int64) (string, error) {
+	var envelope struct {
+		Version  string
+		Revision int64
+		Data     json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(data), &envelope); err != nil {
+		return "", err
+	}
+
+	envelope.Revision = revision
+	b, err := json.Marshal(envelope)
+	return string(b), err
+}
+
+//unwrap returns the Model wrapped by m if any
+func unwrap(m Model) Model {
+	if w, ok := m.(*model); ok {
+		return w.Data
+	}
+	return m
+}
+
 func (m *model) GetSchema() *Schema {
-	return m.Data.GetSchema()
+	return schemaOf(m.Data)
 }
 
 func (m *model) Validate() error {
@@ -66,6 +110,21 @@ func (m *model) BeforeInsert() error {
 	return err
 }
 
+//newModel returns a pointer to a new zero value of the type of m
+//whether m is a pointer or a Model implemented by a value type
+func newModel(m Model) (Model, error) {
+	if m == nil {
+		return nil, errors.New("model is nil")
+	}
+
+	t := reflect.TypeOf(m)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return reflect.New(t).Interface().(Model), nil
+}
+
 func (g *gitdb) RegisterModel(dataset string, m Model) bool {
 	if g.registry == nil {
 		g.registry = make(map[string]Model)
@@ -85,3 +144,16 @@ func (g *gitdb) isRegistered(dataset string) bool {
 
 	return false
 }
+
+//model returns the registered Model of dataset falling back to config.Factory
+func (g *gitdb) model(dataset string) Model {
+	if m, ok := g.registry[dataset]; ok {
+		return m
+	}
+
+	if g.config.Factory != nil {
+		return g.config.Factory(dataset)
+	}
+
+	return nil
+}
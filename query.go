@@ -0,0 +1,291 @@
+package gitdb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+type queryOp int
+
+const (
+	queryCond queryOp = iota
+	queryAnd
+	queryOr
+	queryNot
+	queryAll
+)
+
+//Query represents a boolean combination of conditions against the indexes of a dataset.
+//Sort, Limit, Offset and After control the order and pagination of results and
+//are only honoured on the outermost Query
+type Query struct {
+	op       queryOp
+	param    *SearchParam
+	mode     SearchMode
+	children []*Query
+	pattern  *regexp.Regexp //set on the copy of a Query compile returns
+
+	sortBy   string
+	sortDesc bool
+	limit    int
+	offset   int
+	after    string
+}
+
+//All returns a Query matching every record in a dataset
+func All() *Query {
+	return &Query{op: queryAll}
+}
+
+//Where returns a Query matching records whose index satisfies mode against value
+func Where(index string, mode SearchMode, value interface{}) *Query {
+	return &Query{op: queryCond, param: &SearchParam{Index: index, Value: value}, mode: mode}
+}
+
+//Between returns a Query matching records whose index is between from and to inclusive
+func Between(index string, from, to interface{}) *Query {
+	return &Query{op: queryCond, param: &SearchParam{Index: index, Value: from, To: to}, mode: SearchBetween}
+}
+
+//And returns a Query matching records that satisfy all queries
+func And(queries ...*Query) *Query {
+	return &Query{op: queryAnd, children: queries}
+}
+
+//Or returns a Query matching records that satisfy at least one of queries
+func Or(queries ...*Query) *Query {
+	return &Query{op: queryOr, children: queries}
+}
+
+//Not returns a Query matching records that do not satisfy query
+func Not(query *Query) *Query {
+	return &Query{op: queryNot, children: []*Query{query}}
+}
+
+//CaseSensitive makes every condition in q compare strings case-sensitively
+func (q *Query) CaseSensitive() *Query {
+	q.walk(func(q *Query) {
+		if q.op == queryCond {
+			q.param.CaseSensitive = true
+		}
+	})
+	return q
+}
+
+//Sort orders results by the values of index, descending if desc is true.
+//Records with equal values are ordered by id
+func (q *Query) Sort(index string, desc bool) *Query {
+	q.sortBy = index
+	q.sortDesc = desc
+	return q
+}
+
+//Limit restricts results to at most n records
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+//Offset skips the first n results
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+//After returns only results that come after the record with recordID.
+//Pass the id of the last record of a page to get the next page
+func (q *Query) After(recordID string) *Query {
+	q.after = recordID
+	return q
+}
+
+//walk calls fn for q and all of its descendants
+func (q *Query) walk(fn func(q *Query)) {
+	if q == nil {
+		return
+	}
+
+	fn(q)
+	for _, child := range q.children {
+		child.walk(fn)
+	}
+}
+
+//indexes returns the names of all indexes referenced by q
+func (q *Query) indexes() []string {
+	var names []string
+	seen := map[string]bool{}
+
+	q.walk(func(q *Query) {
+		if q.op == queryCond && !seen[q.param.Index] {
+			seen[q.param.Index] = true
+			names = append(names, q.param.Index)
+		}
+		if len(q.sortBy) > 0 && !seen[q.sortBy] {
+			seen[q.sortBy] = true
+			names = append(names, q.sortBy)
+		}
+	})
+
+	return names
+}
+
+//blinded returns a copy of q with the values of conditions on indexes for which isBlind
+//returns true replaced by blind. Only SearchEquals and SearchNotEquals can be blinded
+func (q *Query) blinded(isBlind func(index string) bool, blind func(value interface{}) (interface{}, error)) (*Query, error) {
+	if q == nil {
+		return nil, nil
+	}
+
+	c := *q
+	c.children = make([]*Query, len(q.children))
+	for i, child := range q.children {
+		var err error
+		if c.children[i], err = child.blinded(isBlind, blind); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.op != queryCond || !isBlind(c.param.Index) {
+		return &c, nil
+	}
+
+	if c.mode != SearchEquals && c.mode != SearchNotEquals {
+		return nil, fmt.Errorf("index %s is encrypted and only supports SearchEquals and SearchNotEquals", c.param.Index)
+	}
+
+	param := *c.param
+	value, err := blind(param.Value)
+	if err != nil {
+		return nil, err
+	}
+	param.Value = value
+	c.param = &param
+
+	return &c, nil
+}
+
+//compile returns a copy of q with the patterns of all SearchRegex and SearchGlob conditions
+//compiled. q is left untouched so that it can be run concurrently and changed between runs
+func (q *Query) compile() (*Query, error) {
+	if q == nil {
+		return nil, nil
+	}
+
+	c := *q
+	c.children = make([]*Query, len(q.children))
+	for i, child := range q.children {
+		var err error
+		if c.children[i], err = child.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.op == queryCond && (c.mode == SearchRegex || c.mode == SearchGlob) {
+		var err error
+		if c.pattern, err = compilePattern(c.param, c.mode); err != nil {
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+//match reports whether the record with recordID satisfies q
+//index must return the index with the given name
+func (q *Query) match(recordID string, index func(name string) gdbSimpleIndex) bool {
+	if q == nil {
+		return true
+	}
+
+	switch q.op {
+	case queryCond:
+		value, ok := index(q.param.Index)[recordID]
+		if ok && q.pattern != nil {
+			return q.pattern.MatchString(indexString(value))
+		}
+		return ok && matchIndexValue(value, q.param, q.mode)
+	case queryAnd:
+		for _, child := range q.children {
+			if !child.match(recordID, index) {
+				return false
+			}
+		}
+		return true
+	case queryOr:
+		for _, child := range q.children {
+			if child.match(recordID, index) {
+				return true
+			}
+		}
+		return false
+	case queryNot:
+		return !q.children[0].match(recordID, index)
+	case queryAll:
+		return true
+	}
+
+	return false
+}
+
+//page sorts recordIDs by q's sort index and applies q's cursor, offset and limit.
+//recordIDs must be sorted by id
+func (q *Query) page(recordIDs []string, index func(name string) gdbSimpleIndex) []string {
+	if q == nil {
+		return recordIDs
+	}
+
+	//less orders records by sort value then id
+	less := func(a, b string) bool { return a < b }
+	if len(q.sortBy) > 0 {
+		values := index(q.sortBy)
+		less = func(a, b string) bool {
+			if c := compareIndexValues(values[a], values[b]); c != 0 {
+				return c < 0
+			}
+			return a < b
+		}
+		sort.SliceStable(recordIDs, func(i, j int) bool {
+			return less(recordIDs[i], recordIDs[j])
+		})
+	}
+
+	if q.sortDesc {
+		for i, j := 0, len(recordIDs)-1; i < j; i, j = i+1, j-1 {
+			recordIDs[i], recordIDs[j] = recordIDs[j], recordIDs[i]
+		}
+	}
+
+	if len(q.after) > 0 {
+		start := sort.Search(len(recordIDs), func(i int) bool {
+			if q.sortDesc {
+				return less(recordIDs[i], q.after)
+			}
+			return less(q.after, recordIDs[i])
+		})
+		recordIDs = recordIDs[start:]
+	}
+
+	if q.offset > 0 {
+		if q.offset >= len(recordIDs) {
+			return []string{}
+		}
+		recordIDs = recordIDs[q.offset:]
+	}
+
+	if q.limit > 0 && q.limit < len(recordIDs) {
+		recordIDs = recordIDs[:q.limit]
+	}
+
+	return recordIDs
+}
+
+//searchQuery converts searchParams into a Query that ORs them together under searchMode
+func searchQuery(searchParams []*SearchParam, searchMode SearchMode) *Query {
+	q := &Query{op: queryOr}
+	for _, searchParam := range searchParams {
+		q.children = append(q.children, &Query{op: queryCond, param: searchParam, mode: searchMode})
+	}
+	return q
+}
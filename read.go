@@ -1,11 +1,13 @@
 package gitdb
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
 
 	"github.com/bouggo/log"
 	"github.com/gogitdb/gitdb/v2/internal/db"
@@ -19,7 +21,13 @@ func (g *gitdb) loadBlock(blockFile string) (*db.Block, error) {
 
 	//if block file is not cached, load into cache
 	if _, ok := g.loadedBlocks[blockFile]; !ok {
-		g.loadedBlocks[blockFile] = db.LoadBlock(blockFile, g.config.EncryptionKey)
+		//a missing block file is a new empty block but a corrupt
+		//one must not be overwritten with the records we still have
+		block, err := db.OpenBlock(blockFile, g.config.keys())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		g.loadedBlocks[blockFile] = block
 	}
 
 	return g.loadedBlocks[blockFile], nil
@@ -41,46 +49,40 @@ func (g *gitdb) doGet(id string) (*db.Record, error) {
 		return nil, ErrNoRecords
 	}
 
-	//we used to to a doGetByIndex here but it doesn't work properly
-	//TODO revisit doGetByIndex
-	dataBlock := db.NewEmptyBlock(g.config.EncryptionKey)
-	if err := dataBlock.Hydrate(blockFilePath); err != nil {
+	dataBlock := db.NewEmptyBlock(g.config.keys())
+	if err := g.hydrateBlock(dataBlock, blockFilePath, g.positions(dataset, []string{id}), 1); err != nil {
 		return nil, err
 	}
 
 	return dataBlock.Get(id)
 }
 
-//func (g *gitdb) doGetByIndex(id, dataset string) (*db.Record, error) {
-//	//read id index
-//	indexFile := filepath.Join(g.indexDir(), dataset, "id.json")
-//	if _, ok := g.indexCache[indexFile]; !ok {
-//		g.buildIndexTargeted(dataset)
-//	}
-//
-//	iv, ok := g.indexCache[indexFile][id]
-//	if ok {
-//		dataBlock := db.NewEmptyBlock(g.config.EncryptionKey)
-//		err = dataBlock.HydrateByPositions(blockFilePath, []int{iv.Offset, iv.Len})
-//		if err != nil {
-//			log.Error(err.Error())
-//			return nil, fmt.Errorf("Record %s not found in %s", id, dataset)
-//		}
-//
-//		record, err := dataBlock.Get(id)
-//		if err != nil {
-//			log.Error(err.Error())
-//			return nil, fmt.Errorf("Record %s not found in %s", id, dataset)
-//		}
-//
-//		return record, nil
-//	}
-//
-//	return nil, fmt.Errorf("Record %s not found in %s", id, dataset)
-//}
+//hydrateBlock reads blockFile into dataBlock seeking straight to positions when
+//all want records of the block have a known position and falling back
+//to reading the whole block when they don't or a position has gone stale
+func (g *gitdb) hydrateBlock(dataBlock *db.EmptyBlock, blockFile string, positions map[string][]int, want int) error {
+	if want > 0 && len(positions) == want {
+		err := dataBlock.HydrateByPositions(blockFile, positions)
+		if err == nil {
+			return nil
+		}
+		log.Info("positional read failed, reading whole block: " + err.Error())
+	}
+
+	return dataBlock.Hydrate(blockFile)
+}
 
 //Get hydrates a model with specified id into result Model
 func (g *gitdb) Get(id string, result Model) error {
+	return g.GetContext(context.Background(), id, result)
+}
+
+//GetContext is like Get but returns ctx.Err() if ctx is done before the record is read
+func (g *gitdb) GetContext(ctx context.Context, id string, result Model) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	record, err := g.doGet(id)
 	if err != nil {
 		return err
@@ -91,6 +93,32 @@ func (g *gitdb) Get(id string, result Model) error {
 	return record.Hydrate(result)
 }
 
+//GetWithRevision hydrates m with the record of id and returns the revision of the record
+//so that a later UpdateIf can detect whether it has been written since
+func (g *gitdb) GetWithRevision(id string, result Model) (int64, error) {
+	return g.GetWithRevisionContext(context.Background(), id, result)
+}
+
+//GetWithRevisionContext is like GetWithRevision but returns ctx.Err() if ctx is done before the record is read
+func (g *gitdb) GetWithRevisionContext(ctx context.Context, id string, result Model) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	record, err := g.doGet(id)
+	if err != nil {
+		return 0, err
+	}
+
+	g.events <- newReadEvent("...", id)
+
+	if err := record.Hydrate(result); err != nil {
+		return 0, err
+	}
+
+	return record.Revision()
+}
+
 func (g *gitdb) Exists(id string) error {
 	_, err := g.doGet(id)
 	if err == nil {
@@ -101,17 +129,25 @@ func (g *gitdb) Exists(id string) error {
 }
 
 func (g *gitdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
+	return g.FetchContext(context.Background(), dataset, blocks...)
+}
+
+//FetchContext is like Fetch but stops reading blocks and returns ctx.Err() when ctx is done
+func (g *gitdb) FetchContext(ctx context.Context, dataset string, blocks ...string) ([]*db.Record, error) {
 	if !g.isRegistered(dataset) {
 		return nil, ErrInvalidDataset
 	}
 
-	dataBlock := db.NewEmptyBlock(g.config.EncryptionKey)
+	dataBlock := db.NewEmptyBlock(g.config.keys())
 
 	if len(blocks) > 0 {
 		fullPath := filepath.Join(g.dbDir(), dataset)
 		for _, block := range blocks {
 			blockFile := filepath.Join(fullPath, block+".json")
 			log.Test("Fetching BLOCK records from - " + blockFile)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
 			if err := dataBlock.Hydrate(blockFile); err != nil {
 				return nil, err
 			}
@@ -120,7 +156,7 @@ func (g *gitdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
 		return dataBlock.Records(), nil
 	}
 
-	if err := g.doFetch(dataset, dataBlock); err != nil {
+	if err := g.doFetch(ctx, dataset, dataBlock); err != nil {
 		return nil, err
 	}
 
@@ -128,95 +164,204 @@ func (g *gitdb) Fetch(dataset string, blocks ...string) ([]*db.Record, error) {
 	return dataBlock.Records(), nil
 }
 
-func (g *gitdb) doFetch(dataset string, dataBlock *db.EmptyBlock) error {
+func (g *gitdb) doFetch(ctx context.Context, dataset string, dataBlock *db.EmptyBlock) error {
+	blockFiles, err := g.blockFiles(dataset)
+	if err != nil {
+		return err
+	}
 
+	for _, blockFile := range blockFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := dataBlock.Hydrate(blockFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//blockFiles returns the paths of all block files in dataset
+func (g *gitdb) blockFiles(dataset string) ([]string, error) {
 	fullPath := filepath.Join(g.dbDir(), dataset)
 	//events <- newReadEvent("...", fullPath)
 	log.Info("Fetching records from - " + fullPath)
 	files, err := ioutil.ReadDir(fullPath)
 	if err != nil && !os.IsNotExist(err) {
-		return err
+		return nil, err
 	}
 
 	if os.IsNotExist(err) {
-		return ErrNoRecords
+		return nil, ErrNoRecords
 	}
 
-	var fileName string
+	var blockFiles []string
 	for _, file := range files {
-		fileName = filepath.Join(fullPath, file.Name())
+		fileName := filepath.Join(fullPath, file.Name())
 		if filepath.Ext(fileName) == ".json" {
-			if err := dataBlock.Hydrate(fileName); err != nil {
-				return err
-			}
+			blockFiles = append(blockFiles, fileName)
 		}
 	}
 
-	return nil
+	return blockFiles, nil
+}
+
+//Iterate returns a *Cursor over all records in dataset or in the specified blocks
+func (g *gitdb) Iterate(dataset string, blocks ...string) (*Cursor, error) {
+	if !g.isRegistered(dataset) {
+		return nil, ErrInvalidDataset
+	}
+
+	var blockFiles []string
+	if len(blocks) > 0 {
+		for _, block := range blocks {
+			blockFiles = append(blockFiles, g.blockFilePath(dataset, block))
+		}
+	} else {
+		var err error
+		if blockFiles, err = g.blockFiles(dataset); err != nil {
+			return nil, err
+		}
+	}
+
+	return newCursor(blockFiles, func(blockFile string) ([]*db.Record, error) {
+		log.Test("Iterating BLOCK records from - " + blockFile)
+		dataBlock := db.NewEmptyBlock(g.config.keys())
+		if err := dataBlock.Hydrate(blockFile); err != nil {
+			return nil, err
+		}
+		return dataBlock.Records(), nil
+	}), nil
 }
 
 func (g *gitdb) Search(dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	return g.find(context.Background(), dataset, searchQuery(searchParams, searchMode))
+}
+
+//SearchContext is like Search but stops reading matching records and returns ctx.Err() when ctx is done
+func (g *gitdb) SearchContext(ctx context.Context, dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	return g.find(ctx, dataset, searchQuery(searchParams, searchMode))
+}
+
+//Find returns all records in dataset that satisfy q
+func (g *gitdb) Find(dataset string, q *Query) ([]*db.Record, error) {
+	return g.find(context.Background(), dataset, q)
+}
+
+func (g *gitdb) find(ctx context.Context, dataset string, q *Query) ([]*db.Record, error) {
 	if !g.isRegistered(dataset) {
 		return nil, ErrInvalidDataset
 	}
 
-	//searchBlocks return the position of the record in the block
-	//searchBlocks := map[string][][]int{} //index based
-	searchBlocks := map[string]bool{}
-	matchingRecords := map[string]string{}
-
-	for _, searchParam := range searchParams {
-		indexFile := filepath.Join(g.indexDir(), dataset, searchParam.Index+".json")
-		if _, ok := g.indexCache[indexFile]; !ok {
-			g.buildIndexTargeted(dataset)
-		}
-
-		g.events <- newReadEvent("...", indexFile)
-
-		queryValue := strings.ToLower(searchParam.Value)
-		for recordID, iv := range g.indexCache[indexFile] {
-			addResult := false
-			dbValue := strings.ToLower(iv.(string))
-			switch searchMode {
-			case SearchEquals:
-				addResult = dbValue == queryValue
-			case SearchContains:
-				addResult = strings.Contains(dbValue, queryValue)
-			case SearchStartsWith:
-				addResult = strings.HasPrefix(dbValue, queryValue)
-			case SearchEndsWith:
-				addResult = strings.HasSuffix(dbValue, queryValue)
-			}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-			if addResult {
-				dataset, block, _, err := ParseID(recordID)
-				if err != nil {
-					return nil, err
-				}
+	recordIDs, err := g.queryIndex(dataset, q)
+	if err != nil {
+		return nil, err
+	}
 
-				matchingRecords[recordID] = recordID
-				searchBlocks[g.blockFilePath(dataset, block)] = true
-			}
+	return g.hydrateRecords(ctx, recordIDs)
+}
+
+//queryIndex returns the ids of records in dataset that satisfy q
+//in the order and page requested by q
+func (g *gitdb) queryIndex(dataset string, q *Query) ([]string, error) {
+	q, err := g.blindQuery(dataset, q)
+	if err != nil {
+		return nil, err
+	}
+
+	if q, err = q.compile(); err != nil {
+		return nil, err
+	}
+
+	indexes := map[string]gdbSimpleIndex{}
+	for _, name := range q.indexes() {
+		indexes[name] = g.index(dataset, name)
+	}
+	index := func(name string) gdbSimpleIndex {
+		return indexes[name]
+	}
+
+	var recordIDs []string
+	for recordID := range g.index(dataset, "id") {
+		if q.match(recordID, index) {
+			recordIDs = append(recordIDs, recordID)
+		}
+	}
+
+	sort.Strings(recordIDs)
+	return q.page(recordIDs, index), nil
+}
+
+//hydrateRecords loads records with the given ids from their block files
+//and returns them in the same order as recordIDs unless ctx is done first
+func (g *gitdb) hydrateRecords(ctx context.Context, recordIDs []string) ([]*db.Record, error) {
+	searchBlocks := map[string][]string{}
+	matchingRecords := make(map[string]string, len(recordIDs))
+	datasets := map[string][]string{}
+
+	for _, recordID := range recordIDs {
+		dataset, block, _, err := ParseID(recordID)
+		if err != nil {
+			return nil, err
+		}
+
+		matchingRecords[recordID] = recordID
+		blockFile := g.blockFilePath(dataset, block)
+		searchBlocks[blockFile] = append(searchBlocks[blockFile], recordID)
+		datasets[dataset] = append(datasets[dataset], recordID)
+	}
+
+	positions := map[string][]int{}
+	for dataset, ids := range datasets {
+		for recordID, pos := range g.positions(dataset, ids) {
+			positions[recordID] = pos
 		}
 	}
 
-	resultBlock := db.NewEmptyBlock(g.config.EncryptionKey)
-	//TODO revisit index based search
-	//for block, pos := range searchBlocks {
-	//	blockFile := filepath.Join(g.dbDir(), dataset, block+".json")
-	//	err := resultBlock.HydrateByPositions(blockFile, pos...)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//}
+	resultBlock := db.NewEmptyBlock(g.config.keys())
+	for block, ids := range searchBlocks {
+		blockPositions := map[string][]int{}
+		for _, recordID := range ids {
+			if pos, ok := positions[recordID]; ok {
+				blockPositions[recordID] = pos
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-	for block := range searchBlocks {
-		if err := resultBlock.Hydrate(block); err != nil {
+		if err := g.hydrateBlock(resultBlock, block, blockPositions, len(ids)); err != nil {
+			if errors.Is(err, ErrCorruptBlock) {
+				return nil, err
+			}
 			log.Error(err.Error())
 			continue
 		}
 	}
 
 	resultBlock.Filter(matchingRecords)
-	return resultBlock.Records(), nil
+	return orderRecords(resultBlock.Records(), recordIDs), nil
+}
+
+//orderRecords arranges records in the order of recordIDs
+func orderRecords(records []*db.Record, recordIDs []string) []*db.Record {
+	byID := make(map[string]*db.Record, len(records))
+	for _, record := range records {
+		byID[record.ID()] = record
+	}
+
+	ordered := make([]*db.Record, 0, len(records))
+	for _, recordID := range recordIDs {
+		if record, ok := byID[recordID]; ok {
+			ordered = append(ordered, record)
+		}
+	}
+
+	return ordered
 }
@@ -1,6 +1,11 @@
 package gitdb_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/bouggo/log"
@@ -91,6 +96,69 @@ func TestFetchBlock(t *testing.T) {
 	}
 }
 
+func TestReadContext(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	recID := gitdb.ID(getTestMessage())
+	sp := []*gitdb.SearchParam{{Index: "From", Value: "alice@example.com"}}
+
+	if err := testDb.GetContext(context.Background(), recID, &Message{}); err != nil {
+		t.Errorf("testDb.GetContext failed: %s", err)
+	}
+	if messages, err := testDb.FetchContext(context.Background(), "Message"); err != nil || len(messages) != 10 {
+		t.Errorf("testDb.FetchContext returned %d records and %v, want 10", len(messages), err)
+	}
+	if messages, err := testDb.SearchContext(context.Background(), "Message", sp, gitdb.SearchEquals); err != nil || len(messages) != 10 {
+		t.Errorf("testDb.SearchContext returned %d records and %v, want 10", len(messages), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := testDb.GetContext(ctx, recID, &Message{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.GetContext want: %s, got: %v", context.Canceled, err)
+	}
+	if _, err := testDb.FetchContext(ctx, "Message"); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.FetchContext want: %s, got: %v", context.Canceled, err)
+	}
+	if _, err := testDb.FetchContext(ctx, "Message", "b0"); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.FetchContext of a block want: %s, got: %v", context.Canceled, err)
+	}
+	if _, err := testDb.SearchContext(ctx, "Message", sp, gitdb.SearchEquals); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.SearchContext want: %s, got: %v", context.Canceled, err)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cursor, err := testDb.Iterate("Message")
+	if err != nil {
+		t.Errorf("testDb.Iterate failed: %s", err)
+		return
+	}
+	defer cursor.Close()
+
+	got := 0
+	for cursor.Next() {
+		m := &Message{}
+		if err := cursor.Record().Hydrate(m); err != nil {
+			t.Errorf("record.Hydrate failed: %s", err)
+		}
+		got++
+	}
+
+	if err := cursor.Err(); err != nil {
+		t.Errorf("cursor.Err: %s", err)
+	}
+
+	if want := 10; got != want {
+		t.Errorf("Want: %d, Got: %d", want, got)
+	}
+}
+
 //TODO test correctness of search results
 func TestSearch(t *testing.T) {
 	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
@@ -113,6 +181,242 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchRange(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cases := []struct {
+		name  string
+		param *gitdb.SearchParam
+		mode  gitdb.SearchMode
+		count int
+	}{
+		{"equals", &gitdb.SearchParam{Index: "MessageId", Value: 3}, gitdb.SearchEquals, 1},
+		{"gt", &gitdb.SearchParam{Index: "MessageId", Value: 7}, gitdb.SearchGreaterThan, 2},
+		{"gte", &gitdb.SearchParam{Index: "MessageId", Value: 7}, gitdb.SearchGreaterThanOrEquals, 3},
+		{"string gte", &gitdb.SearchParam{Index: "MessageId", Value: "10"}, gitdb.SearchGreaterThanOrEquals, 8},
+		{"lt", &gitdb.SearchParam{Index: "MessageId", Value: 2}, gitdb.SearchLessThan, 2},
+		{"lte", &gitdb.SearchParam{Index: "MessageId", Value: 2.5}, gitdb.SearchLessThanOrEquals, 3},
+		{"between", &gitdb.SearchParam{Index: "MessageId", Value: 3, To: 5}, gitdb.SearchBetween, 3},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := testDb.Search("Message", []*gitdb.SearchParam{tc.param}, tc.mode)
+			if err != nil {
+				t.Errorf("search failed with error - %s", err)
+				return
+			}
+
+			if len(results) != tc.count {
+				t.Errorf("search result count wrong. want: %d, got: %d", tc.count, len(results))
+			}
+		})
+	}
+}
+
+func TestSearchPattern(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i, from := range []string{"AB-01", "ab-01", "AB-02", "XY-10"} {
+		m := getTestMessageWithId(i)
+		m.From = from
+		if err := testDb.Insert(m); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		query *gitdb.Query
+		count int
+	}{
+		{"equals", gitdb.Where("From", gitdb.SearchEquals, "AB-01"), 2},
+		{"equals case-sensitive", gitdb.Where("From", gitdb.SearchEquals, "AB-01").CaseSensitive(), 1},
+		{"starts-with case-sensitive", gitdb.Where("From", gitdb.SearchStartsWith, "ab").CaseSensitive(), 1},
+		{"regex", gitdb.Where("From", gitdb.SearchRegex, `^AB-\d+$`), 3},
+		{"regex case-sensitive", gitdb.Where("From", gitdb.SearchRegex, `^AB-\d+$`).CaseSensitive(), 2},
+		{"glob", gitdb.Where("From", gitdb.SearchGlob, "ab-0?"), 3},
+		{"glob case-sensitive", gitdb.Where("From", gitdb.SearchGlob, "ab-*").CaseSensitive(), 1},
+		{"glob literal", gitdb.Where("From", gitdb.SearchGlob, "AB.01"), 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := testDb.Find("Message", tc.query)
+			if err != nil {
+				t.Errorf("testDb.Find failed with error - %s", err)
+				return
+			}
+
+			if len(results) != tc.count {
+				t.Errorf("testDb.Find result count wrong. want: %d, got: %d", tc.count, len(results))
+			}
+		})
+	}
+
+	params := []*gitdb.SearchParam{{Index: "From", Value: "ab-01", CaseSensitive: true}}
+	results, err := testDb.Search("Message", params, gitdb.SearchEquals)
+	if err != nil || len(results) != 1 {
+		t.Errorf("testDb.Search want: 1 result, got: %d (%v)", len(results), err)
+	}
+
+	if _, err := testDb.Find("Message", gitdb.Where("From", gitdb.SearchRegex, "(")); err == nil {
+		t.Errorf("testDb.Find should fail with an invalid regex")
+	}
+}
+
+func TestSearchPatternConcurrent(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i, from := range []string{"AB-01", "AB-02", "XY-10"} {
+		m := getTestMessageWithId(i)
+		m.From = from
+		if err := testDb.Insert(m); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//a Query may be shared by concurrent searches
+	q := gitdb.Where("From", gitdb.SearchRegex, `^AB-\d+$`)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			results, err := testDb.Find("Message", q)
+			if err != nil || len(results) != 2 {
+				t.Errorf("testDb.Find want: 2 results, got: %d (%v)", len(results), err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestFind(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cases := []struct {
+		name  string
+		query *gitdb.Query
+		count int
+	}{
+		{"and", gitdb.And(gitdb.Where("From", gitdb.SearchEquals, "alice@example.com"), gitdb.Between("MessageId", 2, 4)), 3},
+		{"or", gitdb.Or(gitdb.Where("MessageId", gitdb.SearchEquals, 1), gitdb.Where("MessageId", gitdb.SearchEquals, 8)), 2},
+		{"not", gitdb.Not(gitdb.Where("MessageId", gitdb.SearchLessThan, 5)), 5},
+		{"not equals", gitdb.And(gitdb.Where("From", gitdb.SearchEquals, "alice@example.com"), gitdb.Where("MessageId", gitdb.SearchNotEquals, 0)), 9},
+		{"no match", gitdb.And(gitdb.Where("From", gitdb.SearchEquals, "bob@example.com"), gitdb.Where("MessageId", gitdb.SearchEquals, 1)), 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := testDb.Find("Message", tc.query)
+			if err != nil {
+				t.Errorf("find failed with error - %s", err)
+				return
+			}
+
+			if len(results) != tc.count {
+				t.Errorf("find result count wrong. want: %d, got: %d", tc.count, len(results))
+			}
+		})
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
+	defer teardown(t)
+
+	cases := []struct {
+		name  string
+		query *gitdb.Query
+		want  []int
+	}{
+		{"sort desc", gitdb.All().Sort("MessageId", true).Limit(3), []int{9, 8, 7}},
+		{"cursor", gitdb.All().Sort("MessageId", true).After("Message/b0/7").Limit(3), []int{6, 5, 4}},
+		{"offset", gitdb.All().Sort("MessageId", true).Offset(8), []int{1, 0}},
+		{"filtered", gitdb.Where("MessageId", gitdb.SearchGreaterThan, 2).Sort("MessageId", false).Offset(1).Limit(2), []int{4, 5}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			results, err := testDb.Find("Message", tc.query)
+			if err != nil {
+				t.Errorf("find failed with error - %s", err)
+				return
+			}
+
+			var got []int
+			for _, record := range results {
+				m := &Message{}
+				if err := record.Hydrate(m); err != nil {
+					t.Errorf("record.Hydrate failed: %s", err)
+				}
+				got = append(got, m.MessageId)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+type Label struct {
+	gitdb.TimeStampedModel
+	LabelId int
+	Value   interface{}
+}
+
+func (l *Label) GetSchema() *gitdb.Schema {
+	indexes := map[string]interface{}{"Value": l.Value}
+	return gitdb.NewSchema("Label", "b0", fmt.Sprintf("%d", l.LabelId), indexes)
+}
+
+func (l *Label) Validate() error            { return nil }
+func (l *Label) IsLockable() bool           { return false }
+func (l *Label) ShouldEncrypt() bool        { return false }
+func (l *Label) GetLockFileNames() []string { return []string{} }
+
+func TestFindSortMixedValues(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Label", &Label{})
+
+	values := []interface{}{"abc", 10, "10", 2.5, nil, 9}
+	for i, v := range values {
+		if err := testDb.Insert(&Label{LabelId: i, Value: v}); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//nil sorts before numbers and numbers before strings
+	want := []int{4, 3, 5, 1, 2, 0}
+	results, err := testDb.Find("Label", gitdb.All().Sort("Value", false))
+	if err != nil {
+		t.Fatalf("testDb.Find failed: %s", err)
+	}
+
+	var got []int
+	for _, record := range results {
+		l := &Label{}
+		if err := record.Hydrate(l); err != nil {
+			t.Errorf("record.Hydrate failed: %s", err)
+		}
+		got = append(got, l.LabelId)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
 func BenchmarkFetch(b *testing.B) {
 	teardown := setup(b, getReadTestConfig(gitdb.RecVersion))
 	defer teardown(b)
@@ -0,0 +1,86 @@
+package gitdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/crypto"
+	"github.com/gogitdb/gitdb/v2/internal/db"
+)
+
+//RotateKey re-encrypts every encrypted record and field from oldKey to newKey, commits the
+//result as one commit and switches the connection to newKey. If RotateKey fails the
+//blocks it rewrote are put back. If it is interrupted, records already rotated only
+//decrypt with newKey until RotateKey is run again which skips them and resumes where it stopped
+func (g *gitdb) RotateKey(oldKey, newKey string) error {
+	if len(newKey) == 0 || oldKey == newKey {
+		return errors.New("new encryption key must be set and differ from the old one")
+	}
+
+	if g.config.KeyProvider != nil {
+		return errors.New("RotateKey rotates Config.EncryptionKey; with a KeyProvider change the current key id instead")
+	}
+
+	defer g.enterWrite(context.Background())()
+
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	log.Info("rotating encryption key")
+	j := newJournal()
+	rotated, err := db.RotateKey(g.dbDir(), crypto.StaticKey(oldKey), crypto.StaticKey(newKey), j.record)
+	g.loadedBlocks = nil
+	if err != nil {
+		//leave the database readable with the current key
+		if rerr := g.rollback(j); rerr != nil {
+			log.Error(rerr.Error())
+		}
+		return err
+	}
+
+	g.config.EncryptionKey = newKey
+	if len(rotated) == 0 {
+		return nil
+	}
+
+	//re-encrypted records have moved within their block files
+	datasets := map[string]bool{}
+	g.indexMu.Lock()
+	for _, blockFile := range rotated {
+		block, err := db.OpenBlock(blockFile, nil)
+		if err != nil {
+			g.indexMu.Unlock()
+			return err
+		}
+		dataset := filepath.Base(filepath.Dir(blockFile))
+		g.cachePositions(dataset, block)
+		datasets[dataset] = true
+	}
+	g.indexUpdated = true
+	g.indexMu.Unlock()
+
+	if err := g.flushIndex(); err != nil {
+		return err
+	}
+
+	//blind indexes are keyed by the encryption key unless Config.IndexKey is set
+	if len(g.config.IndexKey) == 0 {
+		for _, dataset := range sortedKeys(datasets) {
+			if m := g.model(dataset); m != nil && len(schemaOf(m).blind) > 0 {
+				if err := g.Reindex(dataset); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	e := newWriteEvent(fmt.Sprintf("Rotating encryption key of %d blocks", len(rotated)), g.dbDir(), true)
+	g.commit.Add(1)
+	g.events <- e
+	g.waitForCommit(e)
+
+	return nil
+}
@@ -1,23 +1,26 @@
 package gitdb
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bouggo/log"
 )
 
 //Schema holds functions for generating a model id
 type Schema struct {
-	dataset string
-	block   string
-	record  string
-	indexes map[string]interface{}
+	dataset  string
+	block    string
+	record   string
+	indexes  map[string]interface{}
+	fullText map[string]string
+	unique   map[string]bool
+	blind    map[string]bool //indexes of encrypted fields
 
 	internal bool
 }
@@ -31,6 +34,27 @@ func newSchema(name, block, record string, indexes map[string]interface{}) *Sche
 	return &Schema{dataset: name, block: block, record: record, indexes: indexes, internal: true}
 }
 
+//FullText adds a full-text index called name over text to *Schema
+func (a *Schema) FullText(name, text string) *Schema {
+	if a.fullText == nil {
+		a.fullText = make(map[string]string)
+	}
+	a.fullText[name] = text
+	return a
+}
+
+//Unique marks the named indexes of *Schema as unique. Insert rejects a record
+//whose value for a unique index is already used by another record in the dataset
+func (a *Schema) Unique(names ...string) *Schema {
+	if a.unique == nil {
+		a.unique = make(map[string]bool)
+	}
+	for _, name := range names {
+		a.unique[name] = true
+	}
+	return a
+}
+
 //name returns name of schema
 func (a *Schema) name() string {
 	return a.dataset
@@ -68,6 +92,18 @@ func (a *Schema) Validate() error {
 		return fmt.Errorf("%s is a reserved index name", "id")
 	}
 
+	for name := range a.unique {
+		if _, ok := a.indexes[name]; !ok {
+			return fmt.Errorf("unique index %s is not defined", name)
+		}
+	}
+
+	for name := range a.fullText {
+		if !a.validName(name) {
+			return errors.New("Invalid Schema Full-Text Index Name")
+		}
+	}
+
 	return nil
 }
 
@@ -94,7 +130,7 @@ func (a *Schema) validName(name string) bool {
 
 //Indexes returns the index map of a given Model
 func Indexes(m Model) map[string]interface{} {
-	return m.GetSchema().indexes
+	return schemaOf(m).indexes
 }
 
 //ID returns the id of a given Model
@@ -124,14 +160,28 @@ var (
 	BlockBySize BlockMethod = "size"
 	//BlockByCount generates a new block when the number of records has reached a specified count
 	BlockByCount BlockMethod = "count"
+	//BlockByDay generates a block per day e.g 20200131
+	BlockByDay BlockMethod = "day"
+	//BlockByWeek generates a block per ISO week e.g 2020w05
+	BlockByWeek BlockMethod = "week"
+	//BlockByMonth generates a block per month e.g 202001
+	BlockByMonth BlockMethod = "month"
+	//BlockByYear generates a block per year e.g 2020
+	BlockByYear BlockMethod = "year"
 )
 
-//AutoBlock automatically generates block id for a given Model depending on a BlockMethod
+//AutoBlock automatically generates block id for a given Model depending on a BlockMethod.
+//A Model that is already stored keeps its block. Time based methods derive the block of
+//a new Model from TimedModel.BlockTime, falling back to the current time, and ignore n
 func AutoBlock(dbPath string, m Model, method BlockMethod, n int64) string {
+	timed := false
+	switch method {
+	case BlockByDay, BlockByWeek, BlockByMonth, BlockByYear:
+		timed = true
+	}
 
 	var currentBlock int
-	var currentBlockFile os.FileInfo
-	var currentBlockrecords map[string]interface{}
+	var currentBlockMeta *blockMeta
 
 	//being sensible
 	if n <= 0 {
@@ -142,6 +192,9 @@ func AutoBlock(dbPath string, m Model, method BlockMethod, n int64) string {
 	fullPath := filepath.Join(dbPath, "data", dataset)
 
 	if _, err := os.Stat(fullPath); err != nil {
+		if timed {
+			return timeBlock(m, method)
+		}
 		return fmt.Sprintf("b%d", currentBlock)
 	}
 
@@ -154,53 +207,79 @@ func AutoBlock(dbPath string, m Model, method BlockMethod, n int64) string {
 
 	if len(files) == 0 {
 		log.Test("AutoBlock: no blocks found at " + fullPath)
+		if timed {
+			return timeBlock(m, method)
+		}
 		return fmt.Sprintf("b%d", currentBlock)
 	}
 
+	metas := blockMetaCacheFor(dbPath)
 	currentBlock = -1
-	for _, currentBlockFile = range files {
+	for _, currentBlockFile := range files {
 		currentBlockFileName := filepath.Join(fullPath, currentBlockFile.Name())
 		if filepath.Ext(currentBlockFileName) != ".json" {
 			continue
 		}
 
 		currentBlock++
-		//TODO OPTIMIZE read file
-		b, err := ioutil.ReadFile(currentBlockFileName)
+		meta, err := metas.get(currentBlockFileName, currentBlockFile)
 		if err != nil {
 			log.Test("AutoBlock: " + err.Error())
 			log.Error(err.Error())
 			continue
 		}
-
-		currentBlockrecords = make(map[string]interface{})
-		if err := json.Unmarshal(b, &currentBlockrecords); err != nil {
-			log.Error(err.Error())
-			continue
-		}
+		currentBlockMeta = meta
 
 		block := strings.Replace(filepath.Base(currentBlockFileName), filepath.Ext(currentBlockFileName), "", 1)
 		id := fmt.Sprintf("%s/%s/%s", dataset, block, m.GetSchema().record)
 
 		log.Test("AutoBlock: searching for  - " + id)
 		//model already exists return its block
-		if _, ok := currentBlockrecords[id]; ok {
+		if meta.ids[id] {
 			log.Test("AutoBlock: found - " + id)
 			return block
 		}
 	}
 
+	if timed {
+		return timeBlock(m, method)
+	}
+
+	if currentBlockMeta == nil {
+		return fmt.Sprintf("b%d", currentBlock)
+	}
+
 	//is current block at it's size limit?
-	if method == BlockBySize && currentBlockFile.Size() >= n {
+	if method == BlockBySize && currentBlockMeta.size >= n {
 		currentBlock++
 		return fmt.Sprintf("b%d", currentBlock)
 	}
 
 	//record size check
-	log.Test(fmt.Sprintf("AutoBlock: current block count - %d", len(currentBlockrecords)))
-	if method == BlockByCount && len(currentBlockrecords) >= int(n) {
+	log.Test(fmt.Sprintf("AutoBlock: current block count - %d", currentBlockMeta.count))
+	if method == BlockByCount && currentBlockMeta.count >= int(n) {
 		currentBlock++
 	}
 
 	return fmt.Sprintf("b%d", currentBlock)
 }
+
+//timeBlock returns the block of m for a time based BlockMethod
+func timeBlock(m Model, method BlockMethod) string {
+	t := time.Now()
+	if tm, ok := m.(TimedModel); ok {
+		t = tm.BlockTime()
+	}
+
+	switch method {
+	case BlockByDay:
+		return t.Format("20060102")
+	case BlockByWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%dw%02d", year, week)
+	case BlockByMonth:
+		return t.Format("200601")
+	}
+
+	return t.Format("2006")
+}
@@ -2,7 +2,10 @@ package gitdb_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/gogitdb/gitdb/v2"
 )
@@ -35,6 +38,115 @@ func TestAutoBlock(t *testing.T) {
 	}
 }
 
+func TestAutoBlockByTime(t *testing.T) {
+	m := getTestMessage()
+	m.CreatedAt = time.Date(2020, time.January, 31, 10, 0, 0, 0, time.UTC)
+
+	tests := map[gitdb.BlockMethod]string{
+		gitdb.BlockByDay:   "20200131",
+		gitdb.BlockByWeek:  "2020w05",
+		gitdb.BlockByMonth: "202001",
+		gitdb.BlockByYear:  "2020",
+	}
+
+	for method, want := range tests {
+		if got := gitdb.AutoBlock("/non/existent/path", m, method, 0); got != want {
+			t.Errorf("%s want: %s, got: %s", method, want, got)
+		}
+	}
+}
+
+func TestAutoBlockByTimeExisting(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Ticket", &Ticket{})
+
+	if err := testDb.Insert(&Ticket{Block: "2019", TicketNo: 1}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//a stored record keeps its block whatever its time
+	if got := gitdb.AutoBlock(dbPath, &Ticket{TicketNo: 1}, gitdb.BlockByYear, 0); got != "2019" {
+		t.Errorf("existing record want: 2019, got: %s", got)
+	}
+
+	want := time.Now().Format("2006")
+	if got := gitdb.AutoBlock(dbPath, &Ticket{TicketNo: 2}, gitdb.BlockByYear, 0); got != want {
+		t.Errorf("new record want: %s, got: %s", want, got)
+	}
+}
+
+func TestAutoBlockCache(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	insert := func(messageID int) string {
+		m := getTestMessageWithId(messageID)
+		block := gitdb.AutoBlock(dbPath, m, gitdb.BlockByCount, 2)
+		if err := testDb.Insert(m); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+		return block
+	}
+
+	//Message is always stored in b0 but AutoBlock must see every insert
+	for i, want := range []string{"b0", "b0", "b1"} {
+		if got := insert(i); got != want {
+			t.Errorf("insert %d want: %s, got: %s", i, want, got)
+		}
+	}
+
+	if got := gitdb.AutoBlock(dbPath, getTestMessageWithId(1), gitdb.BlockByCount, 2); got != "b0" {
+		t.Errorf("existing record want: b0, got: %s", got)
+	}
+}
+
+func TestAutoBlockConcurrentConn(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessage()); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	otherPath, err := ioutil.TempDir("", "gitdb-autoblock")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(otherPath)
+
+	//AutoBlock looks up the connection to dbPath while other connections open and close
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				gitdb.AutoBlock(dbPath, getTestMessage(), gitdb.BlockByCount, 10)
+			}
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		cfg := gitdb.NewConfig(otherPath)
+		cfg.ConnectionName = "autoblock"
+		conn, err := gitdb.Open(cfg)
+		if err != nil {
+			t.Fatalf("gitdb.Open failed: %s", err)
+		}
+		if err := conn.Close(); err != nil {
+			t.Errorf("conn.Close failed: %s", err)
+		}
+	}
+}
+
 func TestHydrate(t *testing.T) {
 	teardown := setup(t, getReadTestConfig(gitdb.RecVersion))
 	defer teardown(t)
@@ -86,6 +198,17 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateUnique(t *testing.T) {
+	indexes := map[string]interface{}{"Email": "alice@example.com"}
+	if err := gitdb.NewSchema("d1", "b0", "r0", indexes).Unique("Email").Validate(); err != nil {
+		t.Errorf("Validate failed: %s", err)
+	}
+
+	if err := gitdb.NewSchema("d1", "b0", "r0", indexes).Unique("Phone").Validate(); err == nil {
+		t.Errorf("Validate should fail if unique index is not defined")
+	}
+}
+
 func BenchmarkParseId(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i <= b.N; i++ {
@@ -0,0 +1,261 @@
+package gitdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//dateLayouts are the string layouts recognised as time index values
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02"}
+
+//matchIndexValue reports whether an index value satisfies searchParam under searchMode.
+//SearchRegex and SearchGlob are matched by a compiled Query
+func matchIndexValue(dbValue interface{}, searchParam *SearchParam, searchMode SearchMode) bool {
+	caseSensitive := searchParam.CaseSensitive
+	fold := func(v interface{}) string {
+		if caseSensitive {
+			return indexString(v)
+		}
+		return strings.ToLower(indexString(v))
+	}
+	compare := func(value interface{}, want func(c int) bool) bool {
+		c, ok := compareToParam(dbValue, value, caseSensitive)
+		return ok && want(c)
+	}
+
+	switch searchMode {
+	case SearchEquals:
+		return compare(searchParam.Value, func(c int) bool { return c == 0 })
+	case SearchNotEquals:
+		return !matchIndexValue(dbValue, searchParam, SearchEquals)
+	case SearchContains:
+		return strings.Contains(fold(dbValue), fold(searchParam.Value))
+	case SearchStartsWith:
+		return strings.HasPrefix(fold(dbValue), fold(searchParam.Value))
+	case SearchEndsWith:
+		return strings.HasSuffix(fold(dbValue), fold(searchParam.Value))
+	case SearchGreaterThan:
+		return compare(searchParam.Value, func(c int) bool { return c > 0 })
+	case SearchGreaterThanOrEquals:
+		return compare(searchParam.Value, func(c int) bool { return c >= 0 })
+	case SearchLessThan:
+		return compare(searchParam.Value, func(c int) bool { return c < 0 })
+	case SearchLessThanOrEquals:
+		return compare(searchParam.Value, func(c int) bool { return c <= 0 })
+	case SearchBetween:
+		return compare(searchParam.Value, func(c int) bool { return c >= 0 }) &&
+			compare(searchParam.To, func(c int) bool { return c <= 0 })
+	}
+
+	return false
+}
+
+//compilePattern compiles the pattern of a SearchRegex or SearchGlob searchParam
+func compilePattern(searchParam *SearchParam, searchMode SearchMode) (*regexp.Regexp, error) {
+	pattern := indexString(searchParam.Value)
+	if searchMode == SearchGlob {
+		pattern = globToRegex(pattern)
+	}
+
+	if !searchParam.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern for index %s: %s", searchParam.Index, err)
+	}
+
+	return re, nil
+}
+
+//globToRegex converts a wildcard pattern into an anchored regular expression
+func globToRegex(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	return sb.String()
+}
+
+//compareToParam compares an index value with the value of a search parameter by the type
+//of the parameter and returns -1, 0 or +1. Number parameters are compared numerically with
+//number index values, times chronologically with time index values and everything else as
+//strings. ok is false if the index value cannot be compared with the parameter
+func compareToParam(dbValue, value interface{}, caseSensitive bool) (c int, ok bool) {
+	if y, isNumber := typedNumber(value); isNumber {
+		x, ok := typedNumber(dbValue)
+		if !ok {
+			return 0, false
+		}
+		return compareFloats(x, y), true
+	}
+
+	switch value.(type) {
+	case time.Time, *time.Time:
+		y, _ := indexTime(value)
+		x, ok := indexTime(dbValue)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	if caseSensitive {
+		return strings.Compare(indexString(dbValue), indexString(value)), true
+	}
+	return strings.Compare(strings.ToLower(indexString(dbValue)), strings.ToLower(indexString(value))), true
+}
+
+//kinds of index values in the order they are sorted in
+const (
+	kindNil = iota
+	kindNumber
+	kindString
+)
+
+//indexKind returns the kind of an index value. Times are sorted by their string form
+//which is how they are stored in index files
+func indexKind(v interface{}) int {
+	if v == nil {
+		return kindNil
+	}
+	if _, ok := typedNumber(v); ok {
+		return kindNumber
+	}
+	return kindString
+}
+
+//compareIndexValues orders index values for sorting and returns -1, 0 or +1.
+//Values are ordered by kind first, nil before numbers before strings, then
+//numbers numerically and strings case-insensitively
+func compareIndexValues(a, b interface{}) int {
+	ka, kb := indexKind(a), indexKind(b)
+	switch {
+	case ka < kb:
+		return -1
+	case ka > kb:
+		return 1
+	}
+
+	switch ka {
+	case kindNil:
+		return 0
+	case kindNumber:
+		x, _ := typedNumber(a)
+		y, _ := typedNumber(b)
+		return compareFloats(x, y)
+	}
+
+	return strings.Compare(strings.ToLower(indexString(a)), strings.ToLower(indexString(b)))
+}
+
+//compareFloats compares x and y ordering NaN before every other number
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y, math.IsNaN(x) && !math.IsNaN(y):
+		return -1
+	case x > y, math.IsNaN(y) && !math.IsNaN(x):
+		return 1
+	}
+	return 0
+}
+
+//typedNumber converts v to a float64 if it is a number. Unlike indexNumber
+//numeric looking strings are not numbers
+func typedNumber(v interface{}) (float64, bool) {
+	if n, ok := v.(json.Number); ok {
+		return indexNumber(n)
+	}
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.String {
+		return 0, false
+	}
+	return indexNumber(v)
+}
+
+//indexNumber converts an index value to a float64 if it is numeric
+func indexNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case nil, bool, time.Time, *time.Time:
+		return 0, false
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		return indexNumber(rv.String())
+	}
+
+	return 0, false
+}
+
+//indexTime converts an index value to a time.Time if it is a time
+//or a string in one of dateLayouts
+func indexTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case *time.Time:
+		if t != nil {
+			return *t, true
+		}
+	case string:
+		for _, layout := range dateLayouts {
+			if pt, err := time.Parse(layout, strings.TrimSpace(t)); err == nil {
+				return pt, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+//indexString returns the string form of an index value
+func indexString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case json.Number:
+		return s.String()
+	case time.Time:
+		return s.Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprint(v)
+}
@@ -1,12 +1,19 @@
 package gitdb
 
 import (
+	"context"
 	"fmt"
 	"github.com/bouggo/log"
 	"time"
 )
 
 func (g *gitdb) Sync() error {
+	return g.SyncContext(context.Background())
+}
+
+//SyncContext is like Sync but stops pulling from and pushing to the online remote
+//and returns ctx.Err() when ctx is done. Changes already pulled are kept
+func (g *gitdb) SyncContext(ctx context.Context) error {
 	g.syncMu.Lock()
 	defer g.syncMu.Unlock()
 
@@ -19,17 +26,34 @@ func (g *gitdb) Sync() error {
 		return ErrLowBattery
 	}
 
-	log.Info("Syncing database...")
-	changedFiles := g.driver.changedFiles()
-	if err := g.driver.sync(); err != nil {
-		log.Error(err.Error())
-		return ErrDBSyncFailed
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
+	//pulled changes replace blocks so writes wait for the sync to finish
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	log.Info("Syncing database...")
+	changedFiles := g.driver.changedFiles(ctx)
+	err := g.driver.sync(ctx)
+
+	//a failed or cancelled push still leaves the pulled changes merged
 	// reset loaded blocks
 	g.loadedBlocks = nil
 
 	g.buildIndexSmart(changedFiles)
+
+	//records pulled from the online remote may clash with local ones
+	g.checkUniqueIndexes(changedFiles)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Error(err.Error())
+		return ErrDBSyncFailed
+	}
 	return nil
 }
 
@@ -43,11 +67,9 @@ func (g *gitdb) startSyncClock() {
 				log.Test("shutting down sync clock")
 				return
 			case <-ticker.C:
-				g.writeMu.Lock()
 				if err := g.Sync(); err != nil {
 					log.Error(err.Error())
 				}
-				g.writeMu.Unlock()
 			}
 		}
 	}(g)
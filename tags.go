@@ -0,0 +1,181 @@
+package gitdb
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+//taggedField is a Model field with a `gitdb:"index"` and/or `gitdb:"encrypt"` struct tag
+type taggedField struct {
+	name    string   //field path of the field e.g. Address.City
+	path    []string //json key path of the field in record data
+	field   []int    //reflect field index path of the field
+	index   bool
+	unique  bool
+	encrypt bool
+}
+
+//taggedFieldCache caches the tagged fields of Model types
+var taggedFieldCache sync.Map //reflect.Type => []taggedField
+
+//taggedFields returns the fields of m declared as indexes or encrypted with struct tags
+func taggedFields(m interface{}) []taggedField {
+	t := reflect.TypeOf(m)
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if fields, ok := taggedFieldCache.Load(t); ok {
+		return fields.([]taggedField)
+	}
+
+	fields := parseTaggedFields(t, nil, nil, nil, map[reflect.Type]bool{})
+	taggedFieldCache.Store(t, fields)
+	return fields
+}
+
+//taggedIndexes returns the indexes declared with struct tags on m
+func taggedIndexes(m interface{}) []taggedField {
+	var indexes []taggedField
+	for _, f := range taggedFields(m) {
+		if f.index {
+			indexes = append(indexes, f)
+		}
+	}
+	return indexes
+}
+
+//encryptedFields returns the fields of m declared as encrypted with struct tags
+func encryptedFields(m interface{}) []taggedField {
+	var fields []taggedField
+	for _, f := range taggedFields(m) {
+		if f.encrypt {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+//parseTaggedFields walks the fields of struct type t and its nested structs
+//collecting tagged fields. visiting guards against recursive types
+func parseTaggedFields(t reflect.Type, names, path []string, field []int, visiting map[reflect.Type]bool) []taggedField {
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) || visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	var fields []taggedField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue //unexported
+		}
+
+		key := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if len(tag) > 0 {
+			key = tag
+		}
+
+		fieldIndex := append(append([]int{}, field...), i)
+		fieldType := f.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		//embedded structs are flattened into their parent like encoding/json does
+		if f.Anonymous && len(f.Tag.Get("json")) == 0 {
+			fields = append(fields, parseTaggedFields(fieldType, names, path, fieldIndex, visiting)...)
+			continue
+		}
+
+		fieldNames := append(append([]string{}, names...), f.Name)
+		fieldPath := append(append([]string{}, path...), key)
+
+		tagged := taggedField{name: strings.Join(fieldNames, "."), path: fieldPath, field: fieldIndex}
+		for _, opt := range strings.Split(f.Tag.Get("gitdb"), ",") {
+			switch opt {
+			case "index":
+				tagged.index = true
+			case "unique":
+				tagged.unique = true
+			case "encrypt":
+				tagged.encrypt = true
+			}
+		}
+
+		if tagged.index || tagged.encrypt {
+			fields = append(fields, tagged)
+			continue
+		}
+
+		fields = append(fields, parseTaggedFields(fieldType, fieldNames, fieldPath, fieldIndex, visiting)...)
+	}
+
+	return fields
+}
+
+//value returns the value of the index field in v or nil if a pointer on its path is nil
+func (ti taggedField) value(v reflect.Value) interface{} {
+	for _, i := range ti.field {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+
+	return v.Interface()
+}
+
+//schemaOf returns the *Schema of m with the indexes declared by its struct tags merged in
+func schemaOf(m Model) *Schema {
+	m = unwrap(m)
+	schema := m.GetSchema()
+
+	//indexes declared by hand from encrypted fields are blind like tagged ones
+	for name := range encryptedIndexes(m) {
+		if schema.blind == nil {
+			schema.blind = make(map[string]bool)
+		}
+		schema.blind[name] = true
+	}
+
+	tagged := taggedIndexes(m)
+	if len(tagged) == 0 {
+		return schema
+	}
+
+	if schema.indexes == nil {
+		schema.indexes = make(map[string]interface{})
+	}
+
+	v := reflect.ValueOf(m)
+	for _, ti := range tagged {
+		schema.indexes[ti.name] = ti.value(v)
+		if ti.unique {
+			schema.Unique(ti.name)
+		}
+		if ti.encrypt {
+			if schema.blind == nil {
+				schema.blind = make(map[string]bool)
+			}
+			schema.blind[ti.name] = true
+		}
+	}
+
+	return schema
+}
@@ -1,52 +1,806 @@
 package gitdb
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/bouggo/log"
+	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
-type operation func() error
+//operation is run by Commit with a context that marks the writes made with it as writes of the operation
+type operation func(ctx context.Context) error
 
-// Transaction represents a db transaction
+// Transaction represents a db transaction. Writes made with the methods of a
+// Transaction are validated when they are made, buffered and only become visible
+// to other readers when Commit writes them all in one git commit. Reads made with
+// the methods of a Transaction see its own pending writes
 type Transaction interface {
 	Commit() error
+	CommitContext(ctx context.Context) error
 	AddOperation(o operation)
+	Insert(m Model) error
+	Update(id string, fn func(m Model) error) error
+	Delete(id string) error
+	Lock(m Model) error
+	Unlock(m Model) error
+	Get(id string, m Model) error
+	Search(dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error)
 }
 
+var errTransactionDone = errors.New("transaction has already been committed")
+
 type transaction struct {
 	name       string
 	operations []operation
 	db         *gitdb
+
+	mu     sync.Mutex
+	buffer *txBuffer
+	bases  map[string]string //block file => checksum of the block file when the transaction first wrote to it
+	done   bool
 }
 
+//Commit runs the operations added with AddOperation then writes the pending writes of t and
+//commits them. If an operation fails or the pending writes cannot be written, the files written by the
+//operations are put back and nothing is committed. Commit fails with ErrConflict if a block
+//t writes to has been changed by another transaction or write since t first wrote to it
 func (t *transaction) Commit() error {
-	t.db.autoCommit = false
-	for _, o := range t.operations {
-		if err := o(); err != nil {
-			log.Info("Reverting transaction: " + err.Error())
-			err2 := t.db.driver.undo()
-			t.db.autoCommit = true
-			if err2 != nil {
-				err = fmt.Errorf("%s - %s", err.Error(), err2.Error())
+	return t.CommitContext(context.Background())
+}
+
+//CommitContext is like Commit but discards t and returns ctx.Err() if ctx is done before t is
+//written. If ctx is done once t is written it returns an error matching both ErrCommitPending
+//and ctx.Err() without waiting for the commit, which still happens
+func (t *transaction) CommitContext(ctx context.Context) error {
+	t.mu.Lock()
+	done := t.done
+	t.mu.Unlock()
+	if done {
+		return errTransactionDone
+	}
+
+	//the pending writes of t are written as part of its operations, if any
+	opCtx := ctx
+	var ops *journal
+	if len(t.operations) > 0 {
+		opCtx, ops = t.db.startOperations(ctx)
+		for _, o := range t.operations {
+			if err := o(opCtx); err != nil {
+				log.Info("Reverting transaction: " + err.Error())
+				t.discard()
+				return t.db.revertOperations(ops, err)
 			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		t.discard()
+		return t.db.revertOperations(ops, err)
+	}
+
+	defer t.db.enterWrite(opCtx)()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return t.db.revertOperations(ops, errTransactionDone)
+	}
+	t.done = true
+
+	if t.buffer.empty() && ops == nil {
+		return nil
+	}
+
+	changes, err := t.db.writeTransaction(t)
+	if err != nil {
+		return t.db.revertOperations(ops, err)
+	}
 
+	if ops != nil {
+		if err := t.db.endOperations(ops, false); err != nil {
 			return err
 		}
 	}
 
-	t.db.autoCommit = true
-	commitMsg := "Committing transaction: " + t.name
+	//list the affected records in the body of the commit message
+	commitMsg := "Committing transaction: " + t.name + "\n\n" + strings.Join(changes, "\n")
+	e := newWriteEvent(commitMsg, ".", t.db.autoCommit())
 	t.db.commit.Add(1)
-	t.db.events <- newWriteEvent(commitMsg, ".", t.db.autoCommit)
-	t.db.waitForCommit()
-	return nil
+	t.db.events <- e
+	return t.db.waitForCommitContext(ctx, e)
 }
 
+//AddOperation adds o to the operations Commit runs before it writes the pending writes of t.
+//Operations may write with the Context methods of the connection, or a Transaction committed with
+//CommitContext, passing the ctx o is called with: until t is committed those writes are journaled in
+//the transaction's own journal so that they can be rolled back and are not committed. Any other write
+//waits until the operations have finished
 func (t *transaction) AddOperation(o operation) {
 	t.operations = append(t.operations, o)
 }
 
+//opsKey is the context key of the journal of running transaction operations
+type opsKey struct{}
+
+//startOperations starts journaling writes made with the context it returns to the journal it returns
+//until endOperations is called with it. Writes made meanwhile are not committed and writes made without
+//the context cannot be made until then. Operations started with the context of running operations
+//run within them and are journaled separately so that they can be rolled back on their own
+func (g *gitdb) startOperations(ctx context.Context) (context.Context, *journal) {
+	parent := g.runningOperations(ctx)
+	if parent == nil {
+		g.opGate.Lock()
+	}
+
+	j := newJournal()
+	j.parent = parent
+	g.writeMu.Lock()
+	g.opJournal = j
+	g.writeMu.Unlock()
+	return context.WithValue(ctx, opsKey{}, j), j
+}
+
+//endOperations stops journaling writes to j, restoring the files recorded in j if rollback is set.
+//Otherwise the files are recorded in the journal of the operations j ran within, if any
+func (g *gitdb) endOperations(j *journal, rollback bool) error {
+	if j.parent == nil {
+		defer g.opGate.Unlock()
+	}
+
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	g.opJournal = j.parent
+	if rollback {
+		return g.rollback(j)
+	}
+	if j.parent != nil {
+		j.parent.merge(j)
+	}
+	return nil
+}
+
+//runningOperations returns the journal of the running operations ctx was passed to, if any
+func (g *gitdb) runningOperations(ctx context.Context) *journal {
+	j, ok := ctx.Value(opsKey{}).(*journal)
+	if !ok {
+		return nil
+	}
+
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	for running := g.opJournal; running != nil; running = running.parent {
+		if running == j {
+			return j
+		}
+	}
+	return nil
+}
+
+//enterWrite waits until no transaction operations are running unless ctx was passed to them, so that
+//a write is neither journaled nor rolled back with operations it is not part of. The returned func must
+//be called when the write is done
+func (g *gitdb) enterWrite(ctx context.Context) func() {
+	if g.runningOperations(ctx) != nil {
+		return func() {}
+	}
+
+	g.opGate.RLock()
+	return g.opGate.RUnlock
+}
+
+//revertOperations rolls back the writes of operations journaled in j, if any, and returns err
+func (g *gitdb) revertOperations(j *journal, err error) error {
+	if j == nil {
+		return err
+	}
+
+	if rerr := g.endOperations(j, true); rerr != nil {
+		return fmt.Errorf("%s - %s", err.Error(), rerr.Error())
+	}
+	return err
+}
+
+//autoCommit reports whether writes should be committed as they are made
+//rather than by the transaction whose operations are running
+func (g *gitdb) autoCommit() bool {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	return g.opJournal == nil
+}
+
+//journalWrite records the content of path in the journal of the running
+//transaction operations, if any, before path is written or removed
+func (g *gitdb) journalWrite(path string) error {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	if g.opJournal == nil {
+		return nil
+	}
+	return g.opJournal.record(path)
+}
+
+//discard drops the pending writes of t
+func (t *transaction) discard() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = true
+	t.buffer = newTxBuffer()
+	t.bases = map[string]string{}
+}
+
+//Insert adds m to the pending writes of t
+func (t *transaction) Insert(mo Model) error {
+	m, err := prepareInsert(mo)
+	if err != nil {
+		return err
+	}
+
+	schema := m.GetSchema()
+	if !t.db.isRegistered(schema.name()) {
+		return ErrInvalidDataset
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return errTransactionDone
+	}
+
+	if err := t.checkUnique(m); err != nil {
+		return err
+	}
+
+	r, err := newTxRecord(m)
+	if err != nil {
+		return err
+	}
+
+	if err := t.touch(schema.name(), schema.block); err != nil {
+		return err
+	}
+
+	t.buffer.insert(ID(m), r)
+	return nil
+}
+
+//Update calls fn with the record with id as t would leave it
+//and adds the model as changed by fn to the pending writes of t
+func (t *transaction) Update(id string, fn func(m Model) error) error {
+	dataset, _, _, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+
+	registered := t.db.model(dataset)
+	if registered == nil {
+		return ErrInvalidDataset
+	}
+
+	m, err := newModel(registered)
+	if err != nil {
+		return err
+	}
+	if err := t.Get(id, m); err != nil {
+		return err
+	}
+
+	if err := fn(m); err != nil {
+		return err
+	}
+
+	if ID(m) != id {
+		return fmt.Errorf("Update of %s cannot change its id to %s", id, ID(m))
+	}
+
+	return t.Insert(m)
+}
+
+//Delete adds the deletion of the record with id to the pending writes of t
+func (t *transaction) Delete(id string) error {
+	dataset, block, _, err := ParseID(id)
+	if err != nil {
+		return err
+	}
+
+	if !t.db.isRegistered(dataset) {
+		return ErrInvalidDataset
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return errTransactionDone
+	}
+
+	if err := t.touch(dataset, block); err != nil {
+		return err
+	}
+
+	t.buffer.delete(id)
+	return nil
+}
+
+//Lock adds the lock files of m to the pending writes of t failing if m is already locked
+func (t *transaction) Lock(mo Model) error {
+	lockFiles, err := t.db.lockFiles(mo)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return errTransactionDone
+	}
+
+	for _, lockFile := range lockFiles {
+		if _, ok := t.buffer.locks[lockFile]; ok {
+			return errors.New("Lock file already exist: " + lockFile)
+		}
+		if _, ok := t.buffer.unlocks[lockFile]; ok {
+			continue
+		}
+		if _, err := os.Stat(lockFile); err == nil {
+			return errors.New("Lock file already exist: " + lockFile)
+		}
+	}
+
+	for _, lockFile := range lockFiles {
+		//locking a lock file this transaction unlocks keeps it as it is
+		if _, ok := t.buffer.unlocks[lockFile]; ok {
+			if _, err := os.Stat(lockFile); err == nil {
+				delete(t.buffer.unlocks, lockFile)
+				continue
+			}
+		}
+		t.buffer.lock(lockFile, ID(mo))
+	}
+	return nil
+}
+
+//Unlock adds the removal of the lock files of m to the pending writes of t
+func (t *transaction) Unlock(mo Model) error {
+	lockFiles, err := t.db.lockFiles(mo)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return errTransactionDone
+	}
+
+	for _, lockFile := range lockFiles {
+		t.buffer.unlock(lockFile, ID(mo))
+	}
+	return nil
+}
+
+//Get populates result with the record with id as t would leave it
+func (t *transaction) Get(id string, result Model) error {
+	t.mu.Lock()
+	found, err := t.buffer.get(id, result)
+	t.mu.Unlock()
+
+	if found || err != nil {
+		return err
+	}
+
+	return t.db.Get(id, result)
+}
+
+//Search returns the records of dataset that match searchParams as t would leave them
+func (t *transaction) Search(dataset string, searchParams []*SearchParam, searchMode SearchMode) ([]*db.Record, error) {
+	committed, err := t.db.Search(dataset, searchParams, searchMode)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buffer.search(dataset, searchQuery(searchParams, searchMode), committed)
+}
+
+//touch records the checksum of the block file of dataset and block
+//the first time t writes to it. t.mu must be held
+func (t *transaction) touch(dataset, block string) error {
+	blockFile := t.db.blockFilePath(dataset, block)
+	if _, ok := t.bases[blockFile]; ok {
+		return nil
+	}
+
+	sum, err := blockChecksum(blockFile)
+	if err != nil {
+		return err
+	}
+
+	t.bases[blockFile] = sum
+	return nil
+}
+
+//checkUnique checks the unique indexes of m against committed
+//records and the pending writes of t. t.mu must be held
+func (t *transaction) checkUnique(m Model) error {
+	if err := t.db.checkUniqueExcept(m, t.buffer.touched); err != nil {
+		return err
+	}
+
+	return t.buffer.checkUnique(m)
+}
+
+//checkUniqueRecords checks the unique indexes of the records inserted by t against committed
+//records as they were when the records were inserted. t.mu and g.writeMu must be held
+func (t *transaction) checkUniqueRecords() error {
+	recordIDs := make([]string, 0, len(t.buffer.records))
+	for recordID := range t.buffer.records {
+		recordIDs = append(recordIDs, recordID)
+	}
+	sort.Strings(recordIDs)
+
+	for _, recordID := range recordIDs {
+		r := t.buffer.records[recordID]
+		if err := t.db.checkUniqueValues(r.model.GetSchema(), recordID, r.indexes, t.buffer.touched); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//writeTransaction writes the pending writes of t to their block and lock files rolling
+//back every file written if any write fails and returns a description of each change.
+//Blocks and locks are checked for conflicting writes before anything is written
+func (g *gitdb) writeTransaction(t *transaction) ([]string, error) {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	blockFiles := make([]string, 0, len(t.bases))
+	for blockFile, base := range t.bases {
+		sum, err := blockChecksum(blockFile)
+		if err != nil {
+			return nil, err
+		}
+		if sum != base {
+			block := filepath.Join(filepath.Base(filepath.Dir(blockFile)), filepath.Base(blockFile))
+			return nil, fmt.Errorf("%w: %s was written to during transaction %s", ErrConflict, block, t.name)
+		}
+		blockFiles = append(blockFiles, blockFile)
+	}
+	sort.Strings(blockFiles)
+
+	for lockFile := range t.buffer.locks {
+		if _, err := os.Stat(lockFile); err == nil {
+			return nil, fmt.Errorf("%w: %s was locked during transaction %s", ErrConflict, filepath.Base(lockFile), t.name)
+		}
+	}
+
+	//other writes may have used a unique index value since Insert checked it
+	if err := t.checkUniqueRecords(); err != nil {
+		return nil, err
+	}
+
+	j := newJournal()
+	var changes []string
+	var written []*db.Block
+	for _, blockFile := range blockFiles {
+		block, blockChanges, err := g.applyTransaction(t.buffer, blockFile, j)
+		if err != nil {
+			if rerr := g.rollback(j); rerr != nil {
+				err = fmt.Errorf("%s - %s", err.Error(), rerr.Error())
+			}
+			return nil, err
+		}
+		written = append(written, block)
+		changes = append(changes, blockChanges...)
+	}
+
+	lockChanges, err := applyLocks(t.buffer, j)
+	if err != nil {
+		if rerr := g.rollback(j); rerr != nil {
+			err = fmt.Errorf("%s - %s", err.Error(), rerr.Error())
+		}
+		return nil, err
+	}
+	changes = append(changes, lockChanges...)
+
+	//a transaction committed by the operations of another is rolled back with them
+	if g.opJournal != nil {
+		g.opJournal.merge(j)
+	}
+
+	for _, block := range written {
+		g.updateIndexes(block)
+	}
+
+	for dataset, recordIDs := range t.buffer.deletedByDataset() {
+		g.removeFromIndexes(dataset, recordIDs...)
+	}
+
+	sort.Strings(changes)
+	return changes, nil
+}
+
+//applyTransaction writes the pending writes of buffer to blockFile and returns
+//a description of each change it made. g.writeMu must be held
+func (g *gitdb) applyTransaction(buffer *txBuffer, blockFile string, j *journal) (*db.Block, []string, error) {
+	if err := os.MkdirAll(filepath.Dir(blockFile), 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to make dir %s: %w", filepath.Dir(blockFile), err)
+	}
+
+	block, err := db.OpenBlock(blockFile, g.config.keys())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	var changes []string
+	dataset, blockID := filepath.Base(filepath.Dir(blockFile)), strings.TrimSuffix(filepath.Base(blockFile), ".json")
+	for recordID, r := range buffer.records {
+		if d, b, _, _ := ParseID(recordID); d != dataset || b != blockID {
+			continue
+		}
+
+		change := "Inserting " + recordID
+		var revision int64
+		if record, err := block.Get(recordID); err == nil {
+			change = "Updating " + recordID
+			if revision, err = record.Revision(); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		data, err := setRevision(r.data, revision+1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		data, err = g.encryptRecord(r.model, data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changes = append(changes, change)
+		block.Add(recordID, data)
+	}
+
+	for recordID := range buffer.deleted {
+		if d, b, _, _ := ParseID(recordID); d == dataset && b == blockID {
+			//records that do not exist have nothing to delete
+			if block.Delete(recordID) == nil {
+				changes = append(changes, "Deleting "+recordID)
+			}
+		}
+	}
+
+	return block, changes, g.writeBlockFile(blockFile, block, j)
+}
+
+//applyLocks creates and removes the lock files of buffer and returns
+//a description of each record locked or unlocked
+func applyLocks(buffer *txBuffer, j *journal) ([]string, error) {
+	changes := map[string]bool{}
+	for lockFile, recordID := range buffer.locks {
+		if err := os.MkdirAll(filepath.Dir(lockFile), 0755); err != nil {
+			return nil, err
+		}
+		if err := j.record(lockFile); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(lockFile, []byte(""), 0644); err != nil {
+			return nil, errors.New("Failed to write lock " + lockFile + ": " + err.Error())
+		}
+		changes["Locking "+recordID] = true
+	}
+
+	for lockFile, recordID := range buffer.unlocks {
+		if _, err := os.Stat(lockFile); err != nil {
+			continue
+		}
+		if err := j.record(lockFile); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(lockFile); err != nil {
+			return nil, errors.New("Could not delete lock file: " + lockFile)
+		}
+		changes["Unlocking "+recordID] = true
+	}
+
+	return sortedKeys(changes), nil
+}
+
+//blockChecksum returns the checksum of blockFile or "" if it does not exist
+func blockChecksum(blockFile string) (string, error) {
+	data, err := ioutil.ReadFile(blockFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha1.Sum(data)), nil
+}
+
 func (g *gitdb) StartTransaction(name string) Transaction {
-	return &transaction{name: name, db: g}
+	return &transaction{name: name, db: g, buffer: newTxBuffer(), bases: map[string]string{}}
+}
+
+//txBuffer holds the writes of a transaction that have not been committed
+type txBuffer struct {
+	records map[string]*txRecord //record id => record inserted by the transaction
+	deleted map[string]bool      //ids of records deleted by the transaction
+	locks   map[string]string    //lock file => id of the record locked by the transaction
+	unlocks map[string]string    //lock file => id of the record unlocked by the transaction
+}
+
+//txRecord is a record inserted by a transaction
+type txRecord struct {
+	model   Model
+	data    string                 //record data before encryption
+	indexes map[string]interface{} //index values of model when it was inserted
+}
+
+func newTxBuffer() *txBuffer {
+	return &txBuffer{
+		records: map[string]*txRecord{},
+		deleted: map[string]bool{},
+		locks:   map[string]string{},
+		unlocks: map[string]string{},
+	}
+}
+
+//newTxRecord captures the data and index values of m
+func newTxRecord(m Model) (*txRecord, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := map[string]interface{}{}
+	for name, value := range schemaOf(m).indexes {
+		indexes[name] = value
+	}
+
+	return &txRecord{model: m, data: string(data), indexes: indexes}, nil
+}
+
+func (b *txBuffer) insert(recordID string, r *txRecord) {
+	delete(b.deleted, recordID)
+	b.records[recordID] = r
+}
+
+func (b *txBuffer) delete(recordID string) {
+	delete(b.records, recordID)
+	b.deleted[recordID] = true
+}
+
+func (b *txBuffer) lock(lockFile, recordID string) {
+	delete(b.unlocks, lockFile)
+	b.locks[lockFile] = recordID
+}
+
+func (b *txBuffer) unlock(lockFile, recordID string) {
+	delete(b.locks, lockFile)
+	b.unlocks[lockFile] = recordID
+}
+
+//empty reports whether the transaction has nothing to write
+func (b *txBuffer) empty() bool {
+	return len(b.records) == 0 && len(b.deleted) == 0 && len(b.locks) == 0 && len(b.unlocks) == 0
+}
+
+//touched reports whether the transaction has written or deleted the record with recordID
+func (b *txBuffer) touched(recordID string) bool {
+	_, ok := b.records[recordID]
+	return ok || b.deleted[recordID]
+}
+
+//get populates result with the record with recordID if the transaction has touched it
+func (b *txBuffer) get(recordID string, result Model) (bool, error) {
+	if b.deleted[recordID] {
+		return true, ErrRecordNotFound
+	}
+
+	r, ok := b.records[recordID]
+	if !ok {
+		return false, nil
+	}
+
+	return true, db.NewRecord(recordID, r.data).Hydrate(result)
+}
+
+//search returns committed records that the transaction has not touched
+//together with the records it inserted into dataset that satisfy q
+func (b *txBuffer) search(dataset string, q *Query, committed []*db.Record) ([]*db.Record, error) {
+	q, err := q.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*db.Record
+	for _, record := range committed {
+		if !b.touched(record.ID()) {
+			records = append(records, record)
+		}
+	}
+
+	indexes := map[string]gdbSimpleIndex{}
+	for recordID, r := range b.records {
+		if d, _, _, _ := ParseID(recordID); d != dataset {
+			continue
+		}
+		for name, value := range r.indexes {
+			if _, ok := indexes[name]; !ok {
+				indexes[name] = gdbSimpleIndex{}
+			}
+			indexes[name][recordID] = value
+		}
+	}
+	index := func(name string) gdbSimpleIndex {
+		return indexes[name]
+	}
+
+	for recordID, r := range b.records {
+		if d, _, _, _ := ParseID(recordID); d == dataset && q.match(recordID, index) {
+			records = append(records, db.NewRecord(recordID, r.data))
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID() < records[j].ID()
+	})
+	return records, nil
+}
+
+//checkUnique returns ErrUniqueViolation if a unique index value
+//of m is used by another record inserted by the transaction
+func (b *txBuffer) checkUnique(m Model) error {
+	schema := m.GetSchema()
+	mID := ID(m)
+
+	for name := range schema.unique {
+		value := schema.indexes[name]
+		if isEmptyIndexValue(value) {
+			continue
+		}
+
+		for recordID, r := range b.records {
+			if d, _, _, _ := ParseID(recordID); d != schema.name() || recordID == mID {
+				continue
+			}
+			if matchIndexValue(r.indexes[name], &SearchParam{Value: value}, SearchEquals) {
+				return uniqueViolation(schema.name(), name, value, recordID)
+			}
+		}
+	}
+
+	return nil
+}
+
+//deletedByDataset returns the ids of records deleted by the transaction grouped by dataset
+func (b *txBuffer) deletedByDataset() map[string][]string {
+	deleted := map[string][]string{}
+	for recordID := range b.deleted {
+		if dataset, _, _, err := ParseID(recordID); err == nil {
+			deleted[dataset] = append(deleted[dataset], recordID)
+		}
+	}
+	return deleted
 }
@@ -1,8 +1,15 @@
 package gitdb_test
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gogitdb/gitdb/v2"
 )
@@ -16,12 +23,569 @@ func TestTransaction(t *testing.T) {
 	defer teardown(t)
 
 	tx := testDb.StartTransaction("test")
-	tx.AddOperation(func() error { return nil })
-	tx.AddOperation(func() error { return nil })
-	tx.AddOperation(func() error { return errors.New("test error") })
-	tx.AddOperation(func() error { return nil })
+	tx.AddOperation(func(ctx context.Context) error { return nil })
+	tx.AddOperation(func(ctx context.Context) error { return nil })
+	tx.AddOperation(func(ctx context.Context) error { return errors.New("test error") })
+	tx.AddOperation(func(ctx context.Context) error { return nil })
 	if err := tx.Commit(); err == nil {
 		t.Error("transaction should fail on 3rd operation")
 	}
 
 }
+
+func TestTransactionRollback(t *testing.T) {
+	configs := map[string]*gitdb.Config{
+		"git":   getConfig(),
+		"local": gitdb.NewConfigWithLocalDriver(dbPath),
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.EncryptionKey = ""
+			cfg.KeyProvider = noPatientKeys(getConfig().EncryptionKey)
+			teardown := setup(t, cfg)
+			defer teardown(t)
+			testDb.RegisterModel("Account", &Account{})
+			testDb.RegisterModel("Patient", &Patient{})
+
+			if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+				t.Fatalf("testDb.Insert failed: %s", err)
+			}
+
+			//an uncommitted change that is not part of the transaction
+			unrelated := filepath.Join(cfg.DBPath, "data", "notes.txt")
+			if err := ioutil.WriteFile(unrelated, []byte("keep me"), 0644); err != nil {
+				t.Fatalf("ioutil.WriteFile failed: %s", err)
+			}
+
+			updated := getTestMessageWithId(1)
+			updated.From = "carol@example.com"
+			updated.Body = "Goodbye"
+
+			tx := testDb.StartTransaction("rollback")
+			tx.AddOperation(func(ctx context.Context) error { return tx.Insert(updated) })
+			tx.AddOperation(func(ctx context.Context) error { return tx.Insert(getTestMessageWithId(2)) })
+			tx.AddOperation(func(ctx context.Context) error { return tx.Insert(&Account{AccountNo: 1, Email: "alice@example.com"}) })
+			tx.AddOperation(func(ctx context.Context) error { return errors.New("test error") })
+			if err := tx.Commit(); err == nil {
+				t.Fatal("transaction should fail on 4th operation")
+			}
+			assertRolledBack(t, cfg)
+
+			//Patient/b0 fails to encrypt after the blocks of
+			//Account and Message have been written which must be restored
+			tx = testDb.StartTransaction("rollback")
+			for _, m := range []gitdb.Model{updated, getTestMessageWithId(2), &Account{AccountNo: 1}, &Patient{PatientNo: 1}} {
+				if err := tx.Insert(m); err != nil {
+					t.Fatalf("tx.Insert failed: %s", err)
+				}
+			}
+			if err := tx.Commit(); err == nil {
+				t.Fatal("transaction should fail to encrypt Patient/b0")
+			}
+			assertRolledBack(t, cfg)
+
+			if data, err := ioutil.ReadFile(unrelated); err != nil || string(data) != "keep me" {
+				t.Errorf("unrelated change should be kept, got: %q (%v)", data, err)
+			}
+
+			//the rolled back connection keeps working
+			if err := testDb.Insert(getTestMessageWithId(3)); err != nil {
+				t.Errorf("testDb.Insert after rollback failed: %s", err)
+			}
+		})
+	}
+}
+
+func TestTransactionOperationsRollback(t *testing.T) {
+	configs := map[string]*gitdb.Config{
+		"git":   getConfig(),
+		"local": gitdb.NewConfigWithLocalDriver(dbPath),
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.EncryptionKey = getConfig().EncryptionKey
+			teardown := setup(t, cfg)
+			defer teardown(t)
+			testDb.RegisterModel("Account", &Account{})
+
+			if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+				t.Fatalf("testDb.Insert failed: %s", err)
+			}
+
+			//an uncommitted change that is not part of the transaction
+			unrelated := filepath.Join(cfg.DBPath, "data", "notes.txt")
+			if err := ioutil.WriteFile(unrelated, []byte("keep me"), 0644); err != nil {
+				t.Fatalf("ioutil.WriteFile failed: %s", err)
+			}
+
+			head := func() string {
+				out, _ := exec.Command("git", "-C", filepath.Join(cfg.DBPath, "data"), "rev-parse", "HEAD").CombinedOutput()
+				return string(out)
+			}
+			before := head()
+
+			updated := getTestMessageWithId(1)
+			updated.From = "carol@example.com"
+			updated.Body = "Goodbye"
+
+			//operations that write with the connection are rolled back too
+			tx := testDb.StartTransaction("rollback")
+			tx.AddOperation(func(ctx context.Context) error { return testDb.InsertContext(ctx, updated) })
+			tx.AddOperation(func(ctx context.Context) error { return testDb.InsertContext(ctx, getTestMessageWithId(2)) })
+			tx.AddOperation(func(ctx context.Context) error {
+				return testDb.InsertContext(ctx, &Account{AccountNo: 1, Email: "alice@example.com"})
+			})
+			tx.AddOperation(func(ctx context.Context) error { return errors.New("test error") })
+			if err := tx.Commit(); err == nil {
+				t.Fatal("transaction should fail on 4th operation")
+			}
+			assertRolledBack(t, cfg)
+
+			if data, err := ioutil.ReadFile(unrelated); err != nil || string(data) != "keep me" {
+				t.Errorf("unrelated change should be kept, got: %q (%v)", data, err)
+			}
+
+			if after := head(); after != before {
+				t.Errorf("operations of a failed transaction should not be committed")
+			}
+
+			tx = testDb.StartTransaction("operations")
+			tx.AddOperation(func(ctx context.Context) error { return testDb.InsertContext(ctx, getTestMessageWithId(2)) })
+			tx.AddOperation(func(ctx context.Context) error { return testDb.InsertContext(ctx, getTestMessageWithId(3)) })
+			if err := tx.Commit(); err != nil {
+				t.Fatalf("tx.Commit failed: %s", err)
+			}
+
+			if count, err := testDb.Count("Message", nil); err != nil || count != 3 {
+				t.Errorf("testDb.Count want: 3, got: %d (%v)", count, err)
+			}
+
+			if name == "git" {
+				out, _ := exec.Command("git", "-C", filepath.Join(cfg.DBPath, "data"), "log", "--format=%s", before[:len(before)-1]+"..HEAD").CombinedOutput()
+				if strings.TrimSpace(string(out)) != "Committing transaction: operations" {
+					t.Errorf("operations should be committed together, got: %s", out)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionOperationsConcurrentWrite(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//another goroutine writes to the same block while the operations run
+	written := make(chan error, 1)
+	tx := testDb.StartTransaction("concurrent")
+	tx.AddOperation(func(ctx context.Context) error { return testDb.InsertContext(ctx, getTestMessageWithId(2)) })
+	tx.AddOperation(func(ctx context.Context) error {
+		go func() { written <- testDb.Insert(getTestMessageWithId(3)) }()
+		time.Sleep(50 * time.Millisecond)
+		return errors.New("test error")
+	})
+	if err := tx.Commit(); err == nil {
+		t.Fatal("transaction should fail on 2nd operation")
+	}
+
+	if err := <-written; err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	if err := testDb.Get("Message/b0/2", &Message{}); err == nil {
+		t.Errorf("record inserted by the operations should be rolled back")
+	}
+
+	//the write of the other goroutine is not rolled back with the transaction
+	if err := testDb.Get("Message/b0/3", &Message{}); err != nil {
+		t.Errorf("testDb.Get of concurrent write failed: %s", err)
+	}
+}
+
+func TestTransactionOperationsContext(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	if err := testDb.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	head := func() string {
+		out, _ := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "rev-parse", "HEAD").CombinedOutput()
+		return strings.TrimSpace(string(out))
+	}
+	before := head()
+
+	//writes made with the context of the operations from a goroutine they start or
+	//by a transaction committed within them are part of the operations
+	commit := func(fail bool) error {
+		tx := testDb.StartTransaction("outer")
+		tx.AddOperation(func(ctx context.Context) error {
+			written := make(chan error, 1)
+			go func() { written <- testDb.InsertContext(ctx, getTestMessageWithId(2)) }()
+			return <-written
+		})
+		tx.AddOperation(func(ctx context.Context) error {
+			inner := testDb.StartTransaction("inner")
+			if err := inner.Insert(getTestMessageWithId(3)); err != nil {
+				return err
+			}
+			inner.AddOperation(func(ctx context.Context) error {
+				return testDb.InsertContext(ctx, &MessageV2{MessageId: 4, From: "alice@example.com"})
+			})
+			return inner.CommitContext(ctx)
+		})
+		if fail {
+			tx.AddOperation(func(ctx context.Context) error { return errors.New("test error") })
+		}
+
+		committed := make(chan error, 1)
+		go func() { committed <- tx.Commit() }()
+		select {
+		case err := <-committed:
+			return err
+		case <-time.After(10 * time.Second):
+			t.Fatal("tx.Commit blocked")
+			return nil
+		}
+	}
+
+	if err := commit(true); err == nil {
+		t.Fatal("transaction should fail on 3rd operation")
+	}
+	if count, err := testDb.Count("Message", nil); err != nil || count != 1 {
+		t.Errorf("testDb.Count after a failed transaction want: 1, got: %d (%v)", count, err)
+	}
+	if count, err := testDb.Count("MessageV2", nil); err != nil || count != 0 {
+		t.Errorf("testDb.Count(MessageV2) after a failed transaction want: 0, got: %d (%v)", count, err)
+	}
+	if after := head(); after != before {
+		t.Errorf("operations of a failed transaction should not be committed")
+	}
+
+	if err := commit(false); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+	if count, err := testDb.Count("Message", nil); err != nil || count != 3 {
+		t.Errorf("testDb.Count want: 3, got: %d (%v)", count, err)
+	}
+	if count, err := testDb.Count("MessageV2", nil); err != nil || count != 1 {
+		t.Errorf("testDb.Count(MessageV2) want: 1, got: %d (%v)", count, err)
+	}
+
+	out, _ := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "log", "--format=%s", before+"..HEAD").CombinedOutput()
+	if strings.TrimSpace(string(out)) != "Committing transaction: outer" {
+		t.Errorf("operations should be committed together, got: %s", out)
+	}
+}
+
+//noPatientKeys is a KeyProvider without a key for the Patient dataset
+type noPatientKeys string
+
+func (k noPatientKeys) CurrentKey(dataset string) (string, string, error) {
+	if dataset == "Patient" {
+		return "", "", errors.New("no key for Patient")
+	}
+	return "", string(k), nil
+}
+
+func (k noPatientKeys) Key(dataset, id string) (string, error) {
+	return string(k), nil
+}
+
+func assertRolledBack(t *testing.T, cfg *gitdb.Config) {
+	t.Helper()
+
+	m := &Message{}
+	if err := testDb.Get("Message/b0/1", m); err != nil || m.From != "alice@example.com" {
+		t.Errorf("testDb.Get want original record, got: %+v (%v)", m, err)
+	}
+
+	if err := testDb.Get("Message/b0/2", &Message{}); err == nil {
+		t.Errorf("record inserted by the transaction should be rolled back")
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.DBPath, "data", "Account", "b0.json")); !os.IsNotExist(err) {
+		t.Errorf("block created by the transaction should be removed")
+	}
+
+	records, err := testDb.Search("Message", []*gitdb.SearchParam{{Index: "From", Value: "carol@example.com"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 0 {
+		t.Errorf("testDb.Search want: 0 records, got: %d (%v)", len(records), err)
+	}
+
+	if count, err := testDb.Count("Message", nil); err != nil || count != 1 {
+		t.Errorf("testDb.Count want: 1, got: %d (%v)", count, err)
+	}
+
+	records, err = testDb.SearchText("Message", "Body", "goodbye")
+	if err != nil || len(records) != 0 {
+		t.Errorf("testDb.SearchText want: 0 records, got: %d (%v)", len(records), err)
+	}
+}
+
+func TestTransactionIsolation(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 1; i <= 2; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	m := getTestMessageWithId(3)
+	m.From = "carol@example.com"
+
+	tx := testDb.StartTransaction("isolation")
+	if err := tx.Insert(m); err != nil {
+		t.Fatalf("tx.Insert failed: %s", err)
+	}
+	if err := tx.Delete("Message/b0/1"); err != nil {
+		t.Fatalf("tx.Delete failed: %s", err)
+	}
+
+	//pending writes are invisible outside the transaction
+	if err := testDb.Get("Message/b0/3", &Message{}); err == nil {
+		t.Errorf("testDb.Get should not see pending insert")
+	}
+	if err := testDb.Get("Message/b0/1", &Message{}); err != nil {
+		t.Errorf("testDb.Get should not see pending delete: %s", err)
+	}
+
+	//and visible inside it
+	got := &Message{}
+	if err := tx.Get("Message/b0/3", got); err != nil || got.From != "carol@example.com" {
+		t.Errorf("tx.Get want pending insert, got: %+v (%v)", got, err)
+	}
+	if err := tx.Get("Message/b0/1", &Message{}); !errors.Is(err, gitdb.ErrRecordNotFound) {
+		t.Errorf("tx.Get want: %s, got: %v", gitdb.ErrRecordNotFound, err)
+	}
+	if err := tx.Get("Message/b0/2", &Message{}); err != nil {
+		t.Errorf("tx.Get of committed record failed: %s", err)
+	}
+
+	records, err := tx.Search("Message", []*gitdb.SearchParam{{Index: "From", Value: "example.com"}}, gitdb.SearchEndsWith)
+	if err != nil || len(records) != 2 || records[0].ID() != "Message/b0/2" || records[1].ID() != "Message/b0/3" {
+		t.Errorf("tx.Search want: [Message/b0/2 Message/b0/3], got: %d records (%v)", len(records), err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+
+	if err := testDb.Get("Message/b0/3", &Message{}); err != nil {
+		t.Errorf("testDb.Get after commit failed: %s", err)
+	}
+	if count, err := testDb.Count("Message", nil); err != nil || count != 2 {
+		t.Errorf("testDb.Count want: 2, got: %d (%v)", count, err)
+	}
+
+	if err := tx.Insert(getTestMessageWithId(4)); err == nil {
+		t.Errorf("tx.Insert should fail after Commit")
+	}
+}
+
+func TestTransactionConflict(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	tx1 := testDb.StartTransaction("first")
+	tx2 := testDb.StartTransaction("second")
+	if err := tx1.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("tx1.Insert failed: %s", err)
+	}
+	if err := tx2.Insert(getTestMessageWithId(2)); err != nil {
+		t.Fatalf("tx2.Insert failed: %s", err)
+	}
+
+	if err := tx1.Commit(); err != nil {
+		t.Fatalf("tx1.Commit failed: %s", err)
+	}
+
+	if err := tx2.Commit(); !errors.Is(err, gitdb.ErrConflict) {
+		t.Errorf("tx2.Commit want: %s, got: %v", gitdb.ErrConflict, err)
+	}
+
+	if err := testDb.Get("Message/b0/2", &Message{}); err == nil {
+		t.Errorf("conflicting transaction should not be written")
+	}
+}
+
+func TestTransactionCommitContext(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tx := testDb.StartTransaction("cancelled")
+	if err := tx.Insert(getTestMessageWithId(1)); err != nil {
+		t.Fatalf("tx.Insert failed: %s", err)
+	}
+
+	if err := tx.CommitContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("tx.CommitContext want: %s, got: %v", context.Canceled, err)
+	}
+
+	if err := testDb.Get("Message/b0/1", &Message{}); err == nil {
+		t.Errorf("cancelled transaction should not be written")
+	}
+
+	//a transaction that has been written reports that it is still to be committed
+	tx = testDb.StartTransaction("written")
+	if err := tx.Insert(getTestMessageWithId(2)); err != nil {
+		t.Fatalf("tx.Insert failed: %s", err)
+	}
+
+	release := holdCommits(t)
+	err := tx.CommitContext(&lateContext{Context: ctx})
+	release()
+	if !errors.Is(err, gitdb.ErrCommitPending) || !errors.Is(err, context.Canceled) {
+		t.Errorf("tx.CommitContext want: %s and %s, got: %v", gitdb.ErrCommitPending, context.Canceled, err)
+	}
+
+	if err := testDb.Get("Message/b0/2", &Message{}); err != nil {
+		t.Errorf("written transaction should be kept: %s", err)
+	}
+}
+
+func TestTransactionOperations(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 1; i <= 2; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	tx := testDb.StartTransaction("operations")
+	err := tx.Update("Message/b0/1", func(m gitdb.Model) error {
+		m.(*Message).Body = "Updated"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("tx.Update failed: %s", err)
+	}
+
+	m3 := getTestMessageWithId(3)
+	if err := tx.Insert(m3); err != nil {
+		t.Fatalf("tx.Insert failed: %s", err)
+	}
+	if err := tx.Delete("Message/b0/2"); err != nil {
+		t.Fatalf("tx.Delete failed: %s", err)
+	}
+	if err := tx.Lock(m3); err != nil {
+		t.Fatalf("tx.Lock failed: %s", err)
+	}
+
+	//operations are validated when they are added
+	if err := tx.Lock(m3); err == nil {
+		t.Errorf("tx.Lock should fail if the model is already locked")
+	}
+	if err := tx.Update("Message/b0/9", func(m gitdb.Model) error { return nil }); err == nil {
+		t.Errorf("tx.Update should fail if the record does not exist")
+	}
+	err = tx.Update("Message/b0/1", func(m gitdb.Model) error {
+		m.(*Message).MessageId = 4
+		return nil
+	})
+	if err == nil {
+		t.Errorf("tx.Update should fail if fn changes the record id")
+	}
+	if err := tx.Update("Message/b0/1", func(m gitdb.Model) error { return errors.New("test error") }); err == nil {
+		t.Errorf("tx.Update should fail if fn fails")
+	}
+	if err := tx.Delete("Unknown/b0/1"); !errors.Is(err, gitdb.ErrInvalidDataset) {
+		t.Errorf("tx.Delete want: %s, got: %v", gitdb.ErrInvalidDataset, err)
+	}
+	if err := tx.Insert(&Account{AccountNo: 1}); !errors.Is(err, gitdb.ErrInvalidDataset) {
+		t.Errorf("tx.Insert want: %s, got: %v", gitdb.ErrInvalidDataset, err)
+	}
+
+	//nothing touches disk before Commit
+	lockFile := filepath.Join(dbPath, "data", "Message", "Lock", "3-alice@example.com.lock")
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("lock file should not be written before Commit")
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+
+	m := &Message{}
+	if err := testDb.Get("Message/b0/1", m); err != nil || m.Body != "Updated" {
+		t.Errorf("testDb.Get want updated record, got: %+v (%v)", m, err)
+	}
+	if _, err := os.Stat(lockFile); err != nil {
+		t.Errorf("lock file should be written: %s", err)
+	}
+
+	out, err := exec.Command("git", "-C", filepath.Join(dbPath, "data"), "log", "-1", "--format=%B").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git log failed: %s", err)
+	}
+	want := "Committing transaction: operations\n\nDeleting Message/b0/2\nInserting Message/b0/3\nLocking Message/b0/3\nUpdating Message/b0/1"
+	if strings.TrimSpace(string(out)) != want {
+		t.Errorf("commit message want:\n%s\ngot:\n%s", want, out)
+	}
+
+	//unlocking then locking a locked record leaves it locked
+	tx = testDb.StartTransaction("relock")
+	if err := tx.Unlock(m3); err != nil {
+		t.Fatalf("tx.Unlock failed: %s", err)
+	}
+	if err := tx.Lock(m3); err != nil {
+		t.Fatalf("tx.Lock failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+	if _, err := os.Stat(lockFile); err != nil {
+		t.Errorf("lock file should be kept: %s", err)
+	}
+
+	tx = testDb.StartTransaction("unlock")
+	if err := tx.Unlock(m3); err != nil {
+		t.Fatalf("tx.Unlock failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("lock file should be removed")
+	}
+}
+
+func TestTransactionUpdateValueModel(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Memo", Memo{})
+
+	if err := testDb.Insert(Memo{MessageId: 1, Body: "Hello"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	tx := testDb.StartTransaction("memo")
+	err := tx.Update("Memo/b0/1", func(m gitdb.Model) error {
+		m.(*Memo).Body = "Updated"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("tx.Update failed: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %s", err)
+	}
+
+	m := &Memo{}
+	if err := testDb.Get("Memo/b0/1", m); err != nil || m.Body != "Updated" {
+		t.Errorf("testDb.Get want updated record, got: %+v (%v)", m, err)
+	}
+}
@@ -0,0 +1,120 @@
+package gitdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bouggo/log"
+)
+
+//uniqueViolation constructs an error wrapping ErrUniqueViolation
+func uniqueViolation(dataset, index string, value interface{}, recordIDs ...string) error {
+	return fmt.Errorf("%w: %s.%s value %v is used by %s", ErrUniqueViolation, dataset, index, value, strings.Join(recordIDs, ", "))
+}
+
+//isEmptyIndexValue reports whether v is unset. Unset values are exempt from unique checks
+func isEmptyIndexValue(v interface{}) bool {
+	return v == nil || indexString(v) == ""
+}
+
+//checkUnique returns ErrUniqueViolation if a unique index value of m
+//is already used by another record in its dataset
+func (g *gitdb) checkUnique(m Model) error {
+	return g.checkUniqueExcept(m, nil)
+}
+
+//checkUniqueExcept is checkUnique ignoring records for which skip returns true
+func (g *gitdb) checkUniqueExcept(m Model, skip func(recordID string) bool) error {
+	schema := m.GetSchema()
+	return g.checkUniqueValues(schema, ID(m), schema.indexes, skip)
+}
+
+//checkUniqueValues is checkUniqueExcept for the record mID of schema with index values indexes
+func (g *gitdb) checkUniqueValues(schema *Schema, mID string, indexes map[string]interface{}, skip func(recordID string) bool) error {
+	for name := range schema.unique {
+		value := indexes[name]
+		if isEmptyIndexValue(value) {
+			continue
+		}
+
+		param := &SearchParam{Value: value}
+		if schema.blind[name] {
+			var err error
+			if param.Value, err = g.blindIndex(schema.name(), value); err != nil {
+				return err
+			}
+		}
+
+		for recordID, dbValue := range g.index(schema.name(), name) {
+			if recordID == mID || (skip != nil && skip(recordID)) || !matchIndexValue(dbValue, param, SearchEquals) {
+				continue
+			}
+
+			//the index may still hold records of a reverted transaction
+			if _, err := g.doGet(recordID); err == nil {
+				return uniqueViolation(schema.name(), name, value, recordID)
+			}
+		}
+	}
+
+	return nil
+}
+
+//checkUniqueIndexes reports records in the datasets of changedFiles
+//that share a value of a unique index, as can happen after a Sync
+func (g *gitdb) checkUniqueIndexes(changedFiles []string) []error {
+	datasets := map[string]bool{}
+	for _, blockFile := range changedFiles {
+		datasets[strings.Split(filepath.ToSlash(blockFile), "/")[0]] = true
+	}
+
+	var violations []error
+	for _, dataset := range sortedKeys(datasets) {
+		model := g.model(dataset)
+		if model == nil {
+			continue
+		}
+
+		for name := range schemaOf(model).unique {
+			violations = append(violations, uniqueIndexViolations(dataset, name, g.index(dataset, name))...)
+		}
+	}
+
+	for _, err := range violations {
+		log.Error(err.Error())
+		g.sendMail(newMail("Unique index violation", err.Error()))
+	}
+
+	return violations
+}
+
+//uniqueIndexViolations returns an error for each value in index used by more than one record
+func uniqueIndexViolations(dataset, name string, index gdbSimpleIndex) []error {
+	groups := map[string][]string{}
+	for recordID, value := range index {
+		if isEmptyIndexValue(value) {
+			continue
+		}
+		key := strings.ToLower(indexString(value))
+		groups[key] = append(groups[key], recordID)
+	}
+
+	var keys []string
+	for key, recordIDs := range groups {
+		if len(recordIDs) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var violations []error
+	for _, key := range keys {
+		recordIDs := groups[key]
+		sort.Strings(recordIDs)
+		violations = append(violations, uniqueViolation(dataset, name, index[recordIDs[0]], recordIDs...))
+	}
+
+	return violations
+}
@@ -0,0 +1,141 @@
+package gitdb_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gogitdb/gitdb/v2"
+)
+
+type Account struct {
+	gitdb.TimeStampedModel
+	AccountNo int
+	Email     string
+}
+
+func (a *Account) GetSchema() *gitdb.Schema {
+	indexes := make(map[string]interface{})
+	indexes["Email"] = a.Email
+
+	return gitdb.NewSchema("Account", "b0", fmt.Sprintf("%d", a.AccountNo), indexes).Unique("Email")
+}
+
+func (a *Account) Validate() error     { return nil }
+func (a *Account) IsLockable() bool    { return false }
+func (a *Account) ShouldEncrypt() bool { return false }
+func (a *Account) GetLockFileNames() []string {
+	return []string{}
+}
+
+func TestInsertUnique(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	if err := testDb.Insert(&Account{AccountNo: 1, Email: "alice@example.com"}); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+
+	//updating a record keeps its own value
+	if err := testDb.Insert(&Account{AccountNo: 1, Email: "alice@example.com"}); err != nil {
+		t.Errorf("testDb.Insert update failed: %s", err)
+	}
+
+	err := testDb.Insert(&Account{AccountNo: 2, Email: "Alice@Example.com"})
+	if !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("testDb.Insert want: %s, got: %v", gitdb.ErrUniqueViolation, err)
+	}
+
+	//unset values are not unique
+	for i := 3; i < 5; i++ {
+		if err := testDb.Insert(&Account{AccountNo: i}); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	err = testDb.InsertMany([]gitdb.Model{
+		&Account{AccountNo: 5, Email: "bob@example.com"},
+		&Account{AccountNo: 6, Email: "bob@example.com"},
+	})
+	if !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("testDb.InsertMany want: %s, got: %v", gitdb.ErrUniqueViolation, err)
+	}
+
+	//the reverted transaction must not block the value
+	if err := testDb.Insert(&Account{AccountNo: 7, Email: "bob@example.com"}); err != nil {
+		t.Errorf("testDb.Insert failed: %s", err)
+	}
+}
+
+func TestInsertUniqueConcurrent(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	errs := make(chan error, 8)
+	for i := 1; i <= cap(errs); i++ {
+		go func(accountNo int) {
+			errs <- testDb.Insert(&Account{AccountNo: accountNo, Email: "carol@example.com"})
+		}(i)
+	}
+
+	inserted := 0
+	for i := 0; i < cap(errs); i++ {
+		err := <-errs
+		if err == nil {
+			inserted++
+		} else if !errors.Is(err, gitdb.ErrUniqueViolation) {
+			t.Errorf("testDb.Insert want: %s, got: %s", gitdb.ErrUniqueViolation, err)
+		}
+	}
+
+	if inserted != 1 {
+		t.Errorf("concurrent inserts of a unique value want: 1, got: %d", inserted)
+	}
+}
+
+//Member keeps each record in its own block
+type Member struct {
+	gitdb.TimeStampedModel
+	MemberNo int
+	Email    string
+}
+
+func (m *Member) GetSchema() *gitdb.Schema {
+	indexes := make(map[string]interface{})
+	indexes["Email"] = m.Email
+
+	return gitdb.NewSchema("Member", fmt.Sprintf("b%d", m.MemberNo), fmt.Sprintf("%d", m.MemberNo), indexes).Unique("Email")
+}
+
+func (m *Member) Validate() error     { return nil }
+func (m *Member) IsLockable() bool    { return false }
+func (m *Member) ShouldEncrypt() bool { return false }
+func (m *Member) GetLockFileNames() []string {
+	return []string{}
+}
+
+func TestTransactionUniqueCommit(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+	testDb.RegisterModel("Member", &Member{})
+
+	tx := testDb.StartTransaction("Member")
+	if err := tx.Insert(&Member{MemberNo: 1, Email: "dave@example.com"}); err != nil {
+		t.Fatalf("tx.Insert failed: %s", err)
+	}
+
+	//the value is taken by another write before the transaction commits
+	if err := testDb.Insert(&Member{MemberNo: 2, Email: "dave@example.com"}); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	if err := tx.Commit(); !errors.Is(err, gitdb.ErrUniqueViolation) {
+		t.Errorf("tx.Commit want: %s, got: %v", gitdb.ErrUniqueViolation, err)
+	}
+
+	if count, err := testDb.Count("Member", nil); err != nil || count != 1 {
+		t.Errorf("testDb.Count want: 1, got: %d (%v)", count, err)
+	}
+}
@@ -1,49 +1,92 @@
 package gitdb
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/bouggo/log"
-	"github.com/gogitdb/gitdb/v2/internal/crypto"
 	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
 func (g *gitdb) Insert(mo Model) error {
+	return g.InsertContext(context.Background(), mo)
+}
+
+//InsertContext is like Insert but returns ctx.Err() if ctx is done before mo is written.
+//If ctx is done once mo is written it returns an error matching both ErrCommitPending and
+//ctx.Err() without waiting for the commit, which still happens, so mo must not be written again
+func (g *gitdb) InsertContext(ctx context.Context, mo Model) error {
+	m, err := prepareInsert(mo)
+	if err != nil {
+		return err
+	}
+
+	return g.insert(ctx, m, anyRevision)
+}
+
+//UpdateIf inserts m if the record it replaces is at expectedRevision and fails with
+//ErrConflict if it has been written since. A record that does not exist is at revision 0
+func (g *gitdb) UpdateIf(mo Model, expectedRevision int64) error {
+	return g.UpdateIfContext(context.Background(), mo, expectedRevision)
+}
+
+//UpdateIfContext is like UpdateIf but returns ctx.Err() if ctx is done before mo is written
+//and an error matching ErrCommitPending if ctx is done once it is written like InsertContext
+func (g *gitdb) UpdateIfContext(ctx context.Context, mo Model, expectedRevision int64) error {
+	if expectedRevision < 0 {
+		return fmt.Errorf("invalid revision: %d", expectedRevision)
+	}
+
+	m, err := prepareInsert(mo)
+	if err != nil {
+		return err
+	}
+
+	return g.insert(ctx, m, expectedRevision)
+}
+
+//anyRevision is the expected revision of writes that do not check the revision they replace
+const anyRevision int64 = -1
+
+//prepareInsert validates mo and runs its BeforeInsert hook
+func prepareInsert(mo Model) (*model, error) {
 	m := wrap(mo)
 
 	if err := m.Validate(); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := m.BeforeInsert(); err != nil {
-		return fmt.Errorf("Model.BeforeInsert failed: %s", err)
+		return nil, fmt.Errorf("Model.BeforeInsert failed: %s", err)
 	}
 
 	if err := m.GetSchema().Validate(); err != nil {
-		return err
+		return nil, err
 	}
 
-	return g.insert(m)
+	return m, nil
 }
 
+//InsertMany inserts models in one transaction. Every model is validated
+//before any is written and they are committed in one git commit
 func (g *gitdb) InsertMany(models []Model) error {
 	tx := g.StartTransaction("InsertMany")
-	for _, model := range models {
-		//create a new variable to pass to function to avoid
-		//passing pointer which will end up inserting the same
-		//model multiple times
-		m := model
-		f := func() error { return g.Insert(m) }
-		tx.AddOperation(f)
+	for _, m := range models {
+		if err := tx.Insert(m); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
-func (g *gitdb) insert(m Model) error {
+//insert writes m to its block if the record it replaces is at expectedRevision
+func (g *gitdb) insert(ctx context.Context, m *model, expectedRevision int64) error {
+	defer g.enterWrite(ctx)()
+
 	if !g.isRegistered(m.GetSchema().dataset) {
 		return ErrInvalidDataset
 	}
@@ -55,106 +98,234 @@ func (g *gitdb) insert(m Model) error {
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mID := ID(m)
+	g.events <- newWriteBeforeEvent("...", mID)
+
+	//unique indexes are checked and the block is loaded, its revision checked and
+	//written under the write lock so no other write or sync can change them in between
 	schema := m.GetSchema()
 	blockFilePath := g.blockFilePath(schema.name(), schema.block)
-	dataBlock, err := g.loadBlock(blockFilePath)
+	g.writeMu.Lock()
+	commitMsg, err := g.writeRecord(blockFilePath, m, expectedRevision)
+	g.writeMu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	log.Test(fmt.Sprintf("Size of block before write - %d", dataBlock.Len()))
+	e := newWriteEvent(commitMsg, blockFilePath, g.autoCommit())
+	g.commit.Add(1)
+	g.events <- e
+	log.Test("sent write event to loop")
 
-	//...append new record to block
-	newRecordBytes, err := json.Marshal(m)
+	//block here until write has been committed
+	return g.waitForCommitContext(ctx, e)
+}
+
+//writeRecord loads blockFile and adds m to it with the next revision of its record, failing with
+//ErrUniqueViolation if a unique index value of m is used by another record or ErrConflict if the
+//record is not at expectedRevision, writes the block, updates the indexes and returns a commit
+//message for the write. g.writeMu must be held
+func (g *gitdb) writeRecord(blockFile string, m *model, expectedRevision int64) (string, error) {
+	if err := g.checkUnique(m); err != nil {
+		return "", err
+	}
+
+	dataBlock, err := g.loadBlock(blockFile)
 	if err != nil {
-		return err
+		return "", err
 	}
 
+	log.Test(fmt.Sprintf("Size of block before write - %d", dataBlock.Len()))
+
 	mID := ID(m)
 
 	//construct a commit message
 	commitMsg := "Inserting " + mID
-	if _, err := dataBlock.Get(mID); err == nil {
+	var revision int64
+	if record, err := dataBlock.Get(mID); err == nil {
 		commitMsg = "Updating " + mID
+		if revision, err = record.Revision(); err != nil {
+			return "", err
+		}
 	}
 
-	newRecordStr := string(newRecordBytes)
-	//encrypt data if need be
-	if m.ShouldEncrypt() {
-		newRecordStr = crypto.Encrypt(g.config.EncryptionKey, newRecordStr)
+	if expectedRevision != anyRevision && revision != expectedRevision {
+		return "", fmt.Errorf("%w: %s is at revision %d not %d", ErrConflict, mID, revision, expectedRevision)
 	}
+	m.Revision = revision + 1
 
-	dataBlock.Add(mID, newRecordStr)
+	//...append new record to block
+	newRecordBytes, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
 
-	g.events <- newWriteBeforeEvent("...", mID)
-	if err := g.writeBlock(blockFilePath, dataBlock); err != nil {
-		return err
+	newRecordStr, err := g.encryptRecord(m, string(newRecordBytes))
+	if err != nil {
+		return "", err
 	}
 
-	log.Info(fmt.Sprintf("autoCommit: %v", g.autoCommit))
+	dataBlock.Add(mID, newRecordStr)
+	if err := g.writeBlockFile(blockFile, dataBlock, nil); err != nil {
+		//the cached block no longer matches the block file
+		delete(g.loadedBlocks, blockFile)
+		return "", err
+	}
 
-	g.commit.Add(1)
-	g.events <- newWriteEvent(commitMsg, blockFilePath, g.autoCommit)
-	log.Test("sent write event to loop")
 	g.updateIndexes(dataBlock)
+	return commitMsg, nil
+}
 
-	//block here until write has been committed
-	g.waitForCommit()
+//encryptRecord encrypts data, the JSON of m, if m or any of its fields should be encrypted
+func (g *gitdb) encryptRecord(m Model, data string) (string, error) {
+	schema := m.GetSchema()
+	if m.ShouldEncrypt() {
+		data, err := g.encrypt(schema.name(), data)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt %s: %w", ID(m), err)
+		}
+		return data, nil
+	}
 
-	return nil
+	if fields := encryptedFields(unwrap(m)); len(fields) > 0 {
+		data, err := g.encryptFields(schema.name(), data, fields)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt fields of %s: %w", ID(m), err)
+		}
+		return data, nil
+	}
+
+	return data, nil
+}
+
+//waitForCommit blocks until the event loop has committed e
+func (g *gitdb) waitForCommit(e *dbEvent) {
+	log.Test("waiting for gitdb to commit changes")
+	<-e.done
 }
 
-func (g *gitdb) waitForCommit() {
-	if g.autoCommit {
-		log.Test("waiting for gitdb to commit changes")
-		g.commit.Wait()
+//waitForCommitContext is like waitForCommit but stops waiting when ctx is done returning a
+//commitPendingError. The write has already landed so git is left to finish the commit as killing
+//it can leave the repository locked
+func (g *gitdb) waitForCommitContext(ctx context.Context, e *dbEvent) error {
+	log.Test("waiting for gitdb to commit changes")
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	//the commit may have finished as ctx was done
+	select {
+	case <-e.done:
+		return nil
+	default:
+		return &commitPendingError{err: ctx.Err()}
 	}
 }
 
-func (g *gitdb) writeBlock(blockFile string, block *db.Block) error {
-	g.writeMu.Lock()
-	defer g.writeMu.Unlock()
+//commitPendingError reports that a write landed but its context was done
+//before it was committed. It matches ErrCommitPending and the context's error
+type commitPendingError struct {
+	err error
+}
+
+func (e *commitPendingError) Error() string {
+	return ErrCommitPending.Error() + ": " + e.err.Error()
+}
+
+func (e *commitPendingError) Is(target error) bool {
+	return target == ErrCommitPending
+}
 
-	blockBytes, fmtErr := json.MarshalIndent(block, "", "\t")
+func (e *commitPendingError) Unwrap() error {
+	return e.err
+}
+
+//writeBlockFile writes block to blockFile recording the previous content of blockFile
+//in j or else the journal of running transaction operations. g.writeMu must be held
+func (g *gitdb) writeBlockFile(blockFile string, block *db.Block, j *journal) error {
+	if j == nil {
+		j = g.opJournal
+	}
+
+	if len(g.config.BlockFormat) > 0 {
+		block.SetFormat(db.Format(g.config.BlockFormat))
+	}
+
+	if compress, ok := g.config.Compression[filepath.Base(filepath.Dir(blockFile))]; ok {
+		block.SetCompressed(compress)
+	}
+
+	blockBytes, fmtErr := block.Encode()
 	if fmtErr != nil {
 		return fmtErr
 	}
 
+	if j != nil {
+		if err := j.record(blockFile); err != nil {
+			return err
+		}
+	}
+
 	//update cache
 	if g.loadedBlocks != nil {
 		g.loadedBlocks[blockFile] = block
 	}
-	return ioutil.WriteFile(blockFile, blockBytes, 0744)
+	return db.WriteFileAtomic(blockFile, blockBytes, 0744)
 }
 
 func (g *gitdb) Delete(id string) error {
-	return g.doDelete(id, false)
+	return g.doDelete(context.Background(), id, false)
+}
+
+//DeleteContext is like Delete but returns ctx.Err() if ctx is done before the record is
+//deleted. If ctx is done once it is deleted it returns an error matching both ErrCommitPending
+//and ctx.Err() without waiting for the commit, which still happens
+func (g *gitdb) DeleteContext(ctx context.Context, id string) error {
+	return g.doDelete(ctx, id, false)
 }
 
 func (g *gitdb) DeleteOrFail(id string) error {
-	return g.doDelete(id, true)
+	return g.doDelete(context.Background(), id, true)
 }
 
-func (g *gitdb) doDelete(id string, failNotFound bool) error {
+func (g *gitdb) doDelete(ctx context.Context, id string, failNotFound bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	dataset, block, _, err := ParseID(id)
 	if err != nil {
 		return err
 	}
 
+	defer g.enterWrite(ctx)()
+
+	//the block is loaded and written under the write lock
+	//so no other write or sync can change it in between
 	blockFilePath := g.blockFilePath(dataset, block)
+	g.writeMu.Lock()
 	err = g.delByID(id, blockFilePath, failNotFound)
+	g.writeMu.Unlock()
 
 	if err == nil {
 		log.Test("sending delete event to loop")
+		e := newDeleteEvent(fmt.Sprintf("Deleting %s", id), blockFilePath, g.autoCommit())
 		g.commit.Add(1)
-		g.events <- newDeleteEvent(fmt.Sprintf("Deleting %s", id), blockFilePath, g.autoCommit)
-		g.waitForCommit()
+		g.events <- e
+		return g.waitForCommitContext(ctx, e)
 	}
 
 	return err
 }
 
+//delByID loads blockFile, deletes the record with id from it, writes the
+//block and updates the indexes. g.writeMu must be held
 func (g *gitdb) delByID(id string, blockFile string, failIfNotFound bool) error {
 
 	if _, err := os.Stat(blockFile); err != nil {
@@ -164,7 +335,11 @@ func (g *gitdb) delByID(id string, blockFile string, failIfNotFound bool) error
 		return nil
 	}
 
-	dataBlock := db.LoadBlock(blockFile, g.config.EncryptionKey)
+	dataBlock, err := g.loadBlock(blockFile)
+	if err != nil {
+		return err
+	}
+
 	if err := dataBlock.Delete(id); err != nil {
 		if failIfNotFound {
 			return errors.New("Could not delete [" + id + "]: record does not exist")
@@ -173,5 +348,18 @@ func (g *gitdb) delByID(id string, blockFile string, failIfNotFound bool) error
 	}
 
 	//write undeleted records back to block file
-	return g.writeBlock(blockFile, dataBlock)
+	if err := g.writeBlockFile(blockFile, dataBlock, nil); err != nil {
+		//the cached block no longer matches the block file
+		delete(g.loadedBlocks, blockFile)
+		return err
+	}
+
+	g.removeFromIndexes(dataBlock.Dataset().Name(), id)
+
+	//records after the deleted one have moved in the block file
+	g.indexMu.Lock()
+	g.cachePositions(dataBlock.Dataset().Name(), dataBlock)
+	g.indexMu.Unlock()
+
+	return nil
 }
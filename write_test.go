@@ -1,9 +1,23 @@
 package gitdb_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gogitdb/gitdb/v2"
+	"github.com/gogitdb/gitdb/v2/internal/db"
 )
 
 func TestInsert(t *testing.T) {
@@ -32,6 +46,355 @@ func TestInsertMany(t *testing.T) {
 	}
 }
 
+func TestUpdateIf(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	m := getTestMessage()
+	if err := testDb.UpdateIf(m, 0); err != nil {
+		t.Fatalf("testDb.UpdateIf of a new record failed: %s", err)
+	}
+
+	var got Message
+	revision, err := testDb.GetWithRevision(gitdb.ID(m), &got)
+	if err != nil {
+		t.Fatalf("testDb.GetWithRevision failed: %s", err)
+	}
+	if revision != 1 || got.Body != m.Body {
+		t.Errorf("testDb.GetWithRevision returned revision %d and %+v", revision, got)
+	}
+
+	//a concurrent writer moves the record on to revision 2
+	m.Body = "first"
+	if err := testDb.Insert(m); err != nil {
+		t.Fatal(err)
+	}
+
+	got.Body = "second"
+	if err := testDb.UpdateIf(&got, revision); !errors.Is(err, gitdb.ErrConflict) {
+		t.Errorf("testDb.UpdateIf with a stale revision returned %v, want ErrConflict", err)
+	}
+
+	revision, err = testDb.GetWithRevision(gitdb.ID(m), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != 2 || got.Body != "first" {
+		t.Errorf("testDb.GetWithRevision returned revision %d and body %q, want 2 and %q", revision, got.Body, "first")
+	}
+
+	got.Body = "second"
+	if err := testDb.UpdateIf(&got, revision); err != nil {
+		t.Errorf("testDb.UpdateIf with the current revision failed: %s", err)
+	}
+
+	//transactions move revisions on too
+	tx := testDb.StartTransaction("UpdateIf")
+	if err := tx.Insert(&got); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if revision, err = testDb.GetWithRevision(gitdb.ID(m), &got); err != nil || revision != 4 {
+		t.Errorf("testDb.GetWithRevision after a transaction returned revision %d and %v, want 4", revision, err)
+	}
+}
+
+func TestUpdateIfConcurrent(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	m := getTestMessage()
+	m.Body = "0"
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	//each writer increments the counter in Body, retrying when another writer got in first
+	const writers, increments = 4, 3
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < increments; {
+				var got Message
+				revision, err := testDb.GetWithRevision(gitdb.ID(m), &got)
+				if err != nil {
+					errs <- err
+					return
+				}
+				count, _ := strconv.Atoi(got.Body)
+				got.Body = strconv.Itoa(count + 1)
+				err = testDb.UpdateIf(&got, revision)
+				if errors.Is(err, gitdb.ErrConflict) {
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("testDb.UpdateIf failed: %s", err)
+	}
+
+	var got Message
+	revision, err := testDb.GetWithRevision(gitdb.ID(m), &got)
+	if err != nil {
+		t.Fatalf("testDb.GetWithRevision failed: %s", err)
+	}
+	want := writers * increments
+	if got.Body != strconv.Itoa(want) || revision != int64(want+1) {
+		t.Errorf("testDb.GetWithRevision want body %d at revision %d, got: %q at revision %d", want, want+1, got.Body, revision)
+	}
+}
+
+func TestCorruptBlock(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	m := getTestMessageWithId(0)
+	if err := testDb.Insert(m); err != nil {
+		t.Fatalf("testDb.Insert failed: %s", err)
+	}
+
+	blockDir := filepath.Join(testDb.Config().DBPath, "data", "Message")
+	files, err := ioutil.ReadDir(blockDir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("want only b0.json in %s, got: %d files (%v)", blockDir, len(files), err)
+	}
+
+	blockFile := filepath.Join(blockDir, "b0.json")
+	data, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	//change a byte of the record itself so that the block still parses
+	changed := []byte(string(data))
+	i := bytes.Index(changed, []byte(`"Message/b0/0": "`)) + len(`"Message/b0/0": "`) + 10
+	if changed[i] == 'A' {
+		changed[i] = 'B'
+	} else {
+		changed[i] = 'A'
+	}
+
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"truncated", string(data[:len(data)/2])},
+		{"tampered", strings.Replace(string(data), "Message/b0/0", "Message/b0/1", 1)},
+		{"record changed", string(changed)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(blockFile, []byte(tc.data), 0744); err != nil {
+				t.Fatalf("ioutil.WriteFile failed: %s", err)
+			}
+
+			err := testDb.Get(gitdb.ID(m), &Message{})
+			if !errors.Is(err, gitdb.ErrCorruptBlock) {
+				t.Errorf("testDb.Get want: %s, got: %v", gitdb.ErrCorruptBlock, err)
+			}
+
+			_, err = testDb.Fetch("Message")
+			if !errors.Is(err, gitdb.ErrCorruptBlock) {
+				t.Errorf("testDb.Fetch want: %s, got: %v", gitdb.ErrCorruptBlock, err)
+			}
+		})
+	}
+}
+
+func TestInsertNDJSON(t *testing.T) {
+	cfg := getConfig()
+	cfg.BlockFormat = gitdb.BlockFormatNDJSON
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	for i := 3; i > 0; i-- {
+		if err := testDb.Insert(&Account{AccountNo: i, Email: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	if err := testDb.Delete("Account/b0/2"); err != nil {
+		t.Errorf("testDb.Delete failed: %s", err)
+	}
+
+	blockFile := filepath.Join(cfg.DBPath, "data", "Account", "b0.json")
+	data, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	//one record per line sorted by id with unescaped record data then a trailer
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], `{"id":"Account/b0/1"`) || !strings.HasPrefix(lines[1], `{"id":"Account/b0/3"`) ||
+		lines[2] != `{"end":true}` {
+		t.Fatalf("unexpected ndjson block: %s", data)
+	}
+
+	if !strings.Contains(lines[1], `"Email":"user3@example.com"`) {
+		t.Errorf("record data is not unescaped: %s", lines[1])
+	}
+
+	a := &Account{}
+	if err := testDb.Get("Account/b0/3", a); err != nil || a.Email != "user3@example.com" {
+		t.Errorf("testDb.Get failed: %v", err)
+	}
+
+	records, err := testDb.Search("Account", []*gitdb.SearchParam{{Index: "Email", Value: "user1@example.com"}}, gitdb.SearchEquals)
+	if err != nil || len(records) != 1 {
+		t.Errorf("testDb.Search want: 1 record, got: %d (%v)", len(records), err)
+	}
+
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"tampered", strings.Replace(string(data), "user3@example.com", "user4@example.com", 1)},
+		{"truncated", lines[0] + "\n" + lines[1] + "\n"},
+		{"truncated mid line", lines[0] + "\n" + lines[1][:len(lines[1])/2]},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(blockFile, []byte(tc.data), 0744); err != nil {
+				t.Fatalf("ioutil.WriteFile failed: %s", err)
+			}
+
+			if _, err := testDb.Fetch("Account"); !errors.Is(err, gitdb.ErrCorruptBlock) {
+				t.Errorf("testDb.Fetch want: %s, got: %v", gitdb.ErrCorruptBlock, err)
+			}
+		})
+	}
+}
+
+func TestBlockMerge(t *testing.T) {
+	for _, format := range []db.Format{db.FormatJSON, db.FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "gitdb-merge")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir failed: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			git := func(args ...string) {
+				args = append([]string{"-C", dir, "-c", "user.name=gitdb", "-c", "user.email=gitdb@example.com"}, args...)
+				if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+					t.Fatalf("git %s failed: %s", strings.Join(args, " "), out)
+				}
+			}
+
+			//write a block of 8 records with the bodies in edits
+			blockFile := filepath.Join(dir, "b0.json")
+			write := func(edits map[int]string) {
+				block := db.NewBlock(blockFile, nil)
+				block.SetFormat(format)
+				for i := 1; i <= 8; i++ {
+					body, ok := edits[i]
+					if !ok {
+						body = "Hello"
+					}
+					block.Add(fmt.Sprintf("Message/b0/%d", i), fmt.Sprintf(`{"MessageId":%d,"Body":%q}`, i, body))
+				}
+				data, err := block.Encode()
+				if err != nil {
+					t.Fatalf("block.Encode failed: %s", err)
+				}
+				if err := ioutil.WriteFile(blockFile, data, 0744); err != nil {
+					t.Fatalf("ioutil.WriteFile failed: %s", err)
+				}
+			}
+
+			//edits to different records on two branches merge
+			git("init", "-q")
+			write(nil)
+			git("add", ".")
+			git("commit", "-qm", "base")
+			git("checkout", "-qb", "first")
+			write(map[int]string{1: "first"})
+			git("commit", "-qam", "first")
+			git("checkout", "-q", "-")
+			write(map[int]string{8: "last"})
+			git("commit", "-qam", "last")
+			git("merge", "-q", "--no-edit", "first")
+
+			block, err := db.OpenBlock(blockFile, nil)
+			if err != nil {
+				t.Fatalf("db.OpenBlock of the merged block failed: %s", err)
+			}
+			for id, body := range map[string]string{"Message/b0/1": "first", "Message/b0/8": "last"} {
+				if r, err := block.Get(id); err != nil || !strings.Contains(r.Data(), body) {
+					t.Errorf("merged block want %s with body %s, got: %v", id, body, err)
+				}
+			}
+		})
+	}
+}
+
+func TestInsertCompressed(t *testing.T) {
+	cfg := getConfig()
+	cfg.Compression = map[string]bool{"Account": true}
+	teardown := setup(t, cfg)
+	defer teardown(t)
+	testDb.RegisterModel("Account", &Account{})
+
+	for i := 1; i <= 3; i++ {
+		if err := testDb.Insert(&Account{AccountNo: i, Email: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+			t.Errorf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	blockFile := filepath.Join(cfg.DBPath, "data", "Account", "b0.json")
+	data, err := ioutil.ReadFile(blockFile)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %s", err)
+	}
+
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Errorf("block should be gzip compressed: %q", data[:10])
+	}
+
+	a := &Account{}
+	if err := testDb.Get("Account/b0/2", a); err != nil || a.Email != "user2@example.com" {
+		t.Errorf("testDb.Get failed: %v", err)
+	}
+
+	records, err := testDb.Fetch("Account")
+	if err != nil || len(records) != 3 {
+		t.Errorf("testDb.Fetch want: 3 records, got: %d (%v)", len(records), err)
+	}
+
+	dataset := db.LoadDataset(filepath.Join(cfg.DBPath, "data", "Account"), nil)
+	if dataset.RecordCount() != 3 || dataset.BadBlocksCount() != 0 {
+		t.Errorf("db.LoadDataset want: 3 records, got: %d", dataset.RecordCount())
+	}
+
+	//AutoBlock counts the records of compressed blocks
+	if got := gitdb.AutoBlock(cfg.DBPath, &Account{AccountNo: 4}, gitdb.BlockByCount, 3); got != "b1" {
+		t.Errorf("gitdb.AutoBlock want: b1, got: %s", got)
+	}
+
+	report, err := testDb.VerifyIndexes("Account")
+	if err != nil || !report.OK() {
+		t.Errorf("testDb.VerifyIndexes want: OK, got: %+v (%v)", report, err)
+	}
+}
+
 func BenchmarkInsert(b *testing.B) {
 	teardown := setup(b, nil)
 	defer teardown(b)
@@ -61,6 +424,170 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestDeleteConcurrentInsert(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	for i := 1; i <= 4; i++ {
+		if err := testDb.Insert(getTestMessageWithId(i)); err != nil {
+			t.Fatalf("testDb.Insert failed: %s", err)
+		}
+	}
+
+	//deletes and inserts on the same block must not undo each other
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 1; i <= 4; i++ {
+		wg.Add(2)
+		go func(id int) {
+			defer wg.Done()
+			errs <- testDb.Delete(gitdb.ID(getTestMessageWithId(id)))
+		}(i)
+		go func(id int) {
+			defer wg.Done()
+			errs <- testDb.Insert(getTestMessageWithId(id))
+		}(i + 4)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent write failed: %s", err)
+		}
+	}
+
+	block, err := db.OpenBlock(filepath.Join(dbPath, "data", "Message", "b0.json"), nil)
+	if err != nil {
+		t.Fatalf("db.OpenBlock failed: %s", err)
+	}
+	got := block.RecordIDs()
+	sort.Strings(got)
+	want := []string{"Message/b0/5", "Message/b0/6", "Message/b0/7", "Message/b0/8"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("records want: %v, got: %v", want, got)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := getTestMessage()
+	if err := testDb.InsertContext(ctx, m); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.InsertContext want: %s, got: %v", context.Canceled, err)
+	}
+	if err := testDb.Get(gitdb.ID(m), &Message{}); err == nil {
+		t.Errorf("testDb.InsertContext with a cancelled context should not write %s", gitdb.ID(m))
+	}
+
+	if err := testDb.InsertContext(context.Background(), m); err != nil {
+		t.Fatalf("testDb.InsertContext failed: %s", err)
+	}
+
+	if err := testDb.DeleteContext(ctx, gitdb.ID(m)); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.DeleteContext want: %s, got: %v", context.Canceled, err)
+	}
+	if err := testDb.Get(gitdb.ID(m), &Message{}); err != nil {
+		t.Errorf("testDb.DeleteContext with a cancelled context should not delete %s: %s", gitdb.ID(m), err)
+	}
+
+	if err := testDb.UpdateIfContext(ctx, m, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.UpdateIfContext want: %s, got: %v", context.Canceled, err)
+	}
+	if _, err := testDb.GetWithRevisionContext(ctx, gitdb.ID(m), &Message{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.GetWithRevisionContext want: %s, got: %v", context.Canceled, err)
+	}
+
+	revision, err := testDb.GetWithRevisionContext(context.Background(), gitdb.ID(m), &Message{})
+	if err != nil || revision != 1 {
+		t.Fatalf("testDb.GetWithRevisionContext returned revision %d and %v, want 1", revision, err)
+	}
+	if err := testDb.UpdateIfContext(context.Background(), m, revision); err != nil {
+		t.Errorf("testDb.UpdateIfContext failed: %s", err)
+	}
+
+	if err := testDb.DeleteContext(context.Background(), gitdb.ID(m)); err != nil {
+		t.Errorf("testDb.DeleteContext failed: %s", err)
+	}
+}
+
+//lateContext is done but only reports it after its first Err check, as if it
+//were cancelled after the write started
+type lateContext struct {
+	context.Context
+	checked bool
+}
+
+func (c *lateContext) Err() error {
+	if !c.checked {
+		c.checked = true
+		return nil
+	}
+	return c.Context.Err()
+}
+
+//holdCommits makes the next git commit of the test db wait until the returned func is called
+//so that a write is stored but not yet committed. The db handles no other events meanwhile
+func holdCommits(t *testing.T) func() {
+	gate := filepath.Join(testData, "commit-gate")
+	passed := filepath.Join(testData, "commit-passed")
+	hookFile := filepath.Join(dbPath, "data", ".git", "hooks", "pre-commit")
+
+	if err := ioutil.WriteFile(gate, nil, 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+	hook := "#!/bin/sh\nwhile [ -e " + gate + " ]; do sleep 0.01; done\ntouch " + passed + "\n"
+	if err := ioutil.WriteFile(hookFile, []byte(hook), 0755); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+
+	//release lets the held commit through and waits until it has passed the hook
+	return func() {
+		os.Remove(gate)
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(passed); err == nil {
+				break
+			}
+		}
+		os.Remove(passed)
+		os.Remove(hookFile)
+	}
+}
+
+func TestWriteContextDoneAfterWrite(t *testing.T) {
+	teardown := setup(t, nil)
+	defer teardown(t)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	//a write that has landed reports that it is still to be committed so the caller does not retry it
+	m := getTestMessage()
+	release := holdCommits(t)
+	err := testDb.InsertContext(&lateContext{Context: cancelled}, m)
+	release()
+	if !errors.Is(err, gitdb.ErrCommitPending) || !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.InsertContext want: %s and %s, got: %v", gitdb.ErrCommitPending, context.Canceled, err)
+	}
+	if err := testDb.Get(gitdb.ID(m), &Message{}); err != nil {
+		t.Errorf("testDb.InsertContext should write %s: %s", gitdb.ID(m), err)
+	}
+
+	release = holdCommits(t)
+	err = testDb.DeleteContext(&lateContext{Context: cancelled}, gitdb.ID(m))
+	release()
+	if !errors.Is(err, gitdb.ErrCommitPending) || !errors.Is(err, context.Canceled) {
+		t.Errorf("testDb.DeleteContext want: %s and %s, got: %v", gitdb.ErrCommitPending, context.Canceled, err)
+	}
+	if err := testDb.Get(gitdb.ID(m), &Message{}); err == nil {
+		t.Errorf("testDb.DeleteContext should delete %s", gitdb.ID(m))
+	}
+}
+
 func TestDeleteOrFail(t *testing.T) {
 	teardown := setup(t, nil)
 	defer teardown(t)